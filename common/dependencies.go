@@ -4,6 +4,7 @@ import (
 	redisInit "github.com/SomtoJF/iris-api/initializers/redis"
 	"github.com/SomtoJF/iris-api/initializers/sqldb"
 	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/redis/go-redis/v9"
 	"go.temporal.io/sdk/client"
 	"gorm.io/gorm"
 )
@@ -11,14 +12,20 @@ import (
 type Dependencies interface {
 	GetDB() *gorm.DB
 	GetTemporalClient() client.Client
+	GetRedisClient() *redis.Client
 	GetRedisPubSub() *redispubsub.RedisPubSub
+	GetRedisRateLimiter() *redispubsub.RedisRateLimiter
+	GetRedisLoginGuard() *redispubsub.RedisLoginGuard
 	Cleanup()
 }
 
 type dependencies struct {
 	db             *gorm.DB
 	temporalClient client.Client
+	redisClient    *redis.Client
 	redisPubSub    *redispubsub.RedisPubSub
+	rateLimiter    *redispubsub.RedisRateLimiter
+	loginGuard     *redispubsub.RedisLoginGuard
 }
 
 func (d *dependencies) GetDB() *gorm.DB {
@@ -29,10 +36,22 @@ func (d *dependencies) GetTemporalClient() client.Client {
 	return d.temporalClient
 }
 
+func (d *dependencies) GetRedisClient() *redis.Client {
+	return d.redisClient
+}
+
 func (d *dependencies) GetRedisPubSub() *redispubsub.RedisPubSub {
 	return d.redisPubSub
 }
 
+func (d *dependencies) GetRedisRateLimiter() *redispubsub.RedisRateLimiter {
+	return d.rateLimiter
+}
+
+func (d *dependencies) GetRedisLoginGuard() *redispubsub.RedisLoginGuard {
+	return d.loginGuard
+}
+
 func (d *dependencies) Cleanup() {
 	// Close the Temporal client
 	if d.temporalClient != nil {
@@ -63,10 +82,15 @@ func MakeDependencies() (Dependencies, error) {
 	rdb := redisInit.RedisClient
 
 	redisPubSub := redispubsub.NewRedisPubSub(rdb)
+	rateLimiter := redispubsub.NewRedisRateLimiter(rdb)
+	loginGuard := redispubsub.NewRedisLoginGuard(rdb)
 
 	return &dependencies{
 		db:             db,
 		temporalClient: temporalClient,
+		redisClient:    rdb,
 		redisPubSub:    redisPubSub,
+		rateLimiter:    rateLimiter,
+		loginGuard:     loginGuard,
 	}, nil
 }
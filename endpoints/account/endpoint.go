@@ -0,0 +1,137 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/accountdata"
+	mfaservice "github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+)
+
+type Endpoint struct {
+	accountData *accountdata.Service
+	mfa         *mfaservice.Service
+	passwords   *passwordhash.Hasher
+	sessions    *session.Manager
+	logger      *log.Logger
+}
+
+func NewEndpoint(accountData *accountdata.Service, mfa *mfaservice.Service, passwords *passwordhash.Hasher, sessions *session.Manager, logger *log.Logger) *Endpoint {
+	return &Endpoint{accountData: accountData, mfa: mfa, passwords: passwords, sessions: sessions, logger: logger}
+}
+
+type DeleteAccountRequest struct {
+	// Must match the account email, guards against accidental deletion
+	ConfirmEmail string `json:"confirmEmail" binding:"required"`
+	// Required when the account has a password
+	Password string `json:"password" binding:"max=256"`
+	// Required when two-factor authentication is enabled
+	Code string `json:"code"`
+}
+
+// ExportData godoc
+//
+//	@Summary		Export personal data
+//	@Description	Downloads a zip archive with the user's profile, resumes and job applications as JSON and the original resume files
+//	@Tags			users
+//	@Produce		application/zip
+//	@Success		200	{file}		file					"Data export archive"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/export [get]
+func (e *Endpoint) ExportData(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	fileName := fmt.Sprintf("iris-export-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent once streaming starts, so a failure can only be logged
+	if err := e.accountData.Export(c.Request.Context(), user, c.Writer); err != nil {
+		e.logger.Printf("Failed to export data for user %s: %v", user.IdExternal, err)
+		c.Abort()
+	}
+}
+
+// DeleteAccount godoc
+//
+//	@Summary		Delete account
+//	@Description	Signs the user out everywhere, stops running job applications and schedules the account and its data for permanent deletion after a grace period
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			deleteAccountRequest	body		DeleteAccountRequest	true	"Confirmation"
+//	@Success		200						{object}	map[string]interface{}	"Account scheduled for deletion"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		401						{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me [delete]
+func (e *Endpoint) DeleteAccount(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request DeleteAccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(request.ConfirmEmail), user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation email does not match your account"})
+		return
+	}
+
+	// Accounts created through a social login have no password to check
+	if user.PasswordHash != "" {
+		if match, _, err := e.passwords.Verify(request.Password, user.PasswordHash); err != nil || !match {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+	}
+
+	if user.IsTotpEnabled() {
+		if err := e.mfa.Verify(user, request.Code); err != nil {
+			if errors.Is(err, mfaservice.ErrInvalidCode) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid authentication code"})
+				return
+			}
+			e.logger.Printf("Failed to verify TOTP code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify authentication code"})
+			return
+		}
+	}
+
+	purgeAfter, err := e.accountData.ScheduleDeletion(c.Request.Context(), user)
+	if err != nil {
+		if errors.Is(err, accountdata.ErrAlreadyDeleted) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Account is already scheduled for deletion"})
+			return
+		}
+		e.logger.Printf("Failed to delete account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	e.sessions.ClearCookies(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your account has been deleted",
+		"data":    gin.H{"purgeAfter": purgeAfter},
+	})
+}
@@ -0,0 +1,251 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errLastAdmin = errors.New("cannot remove the last admin")
+
+type Endpoint struct {
+	db       *gorm.DB
+	sessions *session.Manager
+	audit    *audit.Recorder
+	logger   *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, sessions *session.Manager, auditRecorder *audit.Recorder, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, sessions: sessions, audit: auditRecorder, logger: logger}
+}
+
+type FetchUsersRequest struct {
+	Page  int    `form:"page" binding:"required,min=1"`
+	Limit int    `form:"limit" binding:"required,min=1,max=100"`
+	Email string `form:"email"`
+	Role  string `form:"role"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type UserDTO struct {
+	Id              string     `json:"id"`
+	FirstName       string     `json:"firstName"`
+	LastName        string     `json:"lastName"`
+	Email           string     `json:"email"`
+	Role            model.Role `json:"role"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	TotpEnabled     bool       `json:"totpEnabled"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+type FetchUsersResponse struct {
+	Data  []UserDTO `json:"data"`
+	Total int       `json:"total"`
+	Page  int       `json:"page"`
+	Limit int       `json:"limit"`
+}
+
+// FetchUsers godoc
+//
+//	@Summary		List users
+//	@Description	Lists users, optionally filtered by email substring and role
+//	@Tags			admin
+//	@Produce		json
+//	@Param			page	query		int						true	"Page number"
+//	@Param			limit	query		int						true	"Page size"
+//	@Param			email	query		string					false	"Email contains"
+//	@Param			role	query		string					false	"Role"
+//	@Success		200		{object}	FetchUsersResponse		"Users"
+//	@Failure		400		{object}	map[string]interface{}	"Bad request"
+//	@Failure		403		{object}	map[string]interface{}	"Forbidden"
+//	@Failure		500		{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/users [get]
+func (e *Endpoint) FetchUsers(c *gin.Context) {
+	var request FetchUsersRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Role != "" && !model.IsValidRole(request.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + request.Role, "validRoles": model.AllRoles})
+		return
+	}
+
+	query := e.db.Model(&model.User{}).Where("deleted_at IS NULL")
+	if request.Email != "" {
+		query = query.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(request.Email)+"%")
+	}
+	if request.Role != "" {
+		query = query.Where("role = ?", request.Role)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		e.logger.Printf("Failed to count users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	var users []model.User
+	if err := query.Order("created_at DESC").Limit(request.Limit).Offset((request.Page - 1) * request.Limit).Find(&users).Error; err != nil {
+		e.logger.Printf("Failed to fetch users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	userDTOs := make([]UserDTO, 0, len(users))
+	for _, user := range users {
+		userDTOs = append(userDTOs, toUserDTO(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": FetchUsersResponse{
+		Data:  userDTOs,
+		Total: int(total),
+		Page:  request.Page,
+		Limit: request.Limit,
+	}})
+}
+
+// FetchUser godoc
+//
+//	@Summary		Get a user
+//	@Description	Retrieves a single user by id
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		string					true	"User id"
+//	@Success		200	{object}	UserDTO					"User"
+//	@Failure		403	{object}	map[string]interface{}	"Forbidden"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/users/{id} [get]
+func (e *Endpoint) FetchUser(c *gin.Context) {
+	user, ok := e.findUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": toUserDTO(user)})
+}
+
+// UpdateUserRole godoc
+//
+//	@Summary		Change a user's role
+//	@Description	Grants or removes a role. Admins cannot change their own role and the last admin cannot be demoted. The user is signed out of every session.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id						path		string					true	"User id"
+//	@Param			updateUserRoleRequest	body		UpdateUserRoleRequest	true	"New role"
+//	@Success		200						{object}	UserDTO					"Updated user"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		403						{object}	map[string]interface{}	"Forbidden"
+//	@Failure		404						{object}	map[string]interface{}	"Not found"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/users/{id}/role [put]
+func (e *Endpoint) UpdateUserRole(c *gin.Context) {
+	var request UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !model.IsValidRole(request.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + request.Role, "validRoles": model.AllRoles})
+		return
+	}
+
+	user, ok := e.findUser(c)
+	if !ok {
+		return
+	}
+
+	if user.IdUser == c.GetUint("userId") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	role := model.Role(request.Role)
+	if user.Role == role {
+		c.JSON(http.StatusOK, gin.H{"data": toUserDTO(user)})
+		return
+	}
+
+	err := e.db.Transaction(func(tx *gorm.DB) error {
+		if user.Role == model.RoleAdmin {
+			var admins int64
+			if err := tx.Model(&model.User{}).Where("role = ? AND deleted_at IS NULL", model.RoleAdmin).Count(&admins).Error; err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return errLastAdmin
+			}
+		}
+		return tx.Model(&user).Update("role", role).Error
+	})
+	if err != nil {
+		if errors.Is(err, errLastAdmin) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot remove the last admin"})
+			return
+		}
+		e.logger.Printf("Failed to update user role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+		return
+	}
+
+	previousRole := user.Role
+	user.Role = role
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionRoleChanged,
+		Outcome:    model.AuditOutcomeSuccess,
+		UserId:     audit.UserId(user.IdUser),
+		TargetType: "user",
+		TargetId:   user.IdExternal.String(),
+		Metadata:   map[string]interface{}{"from": previousRole, "to": role},
+	})
+
+	// Signed-in sessions started under the old role end, so the user signs in
+	// again with the permissions they have now
+	if err := e.sessions.RevokeAllForUser(user.IdUser, 0, model.SessionRevokedRoleChange); err != nil {
+		e.logger.Printf("Failed to revoke sessions after role change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Role updated but failed to sign out the user's sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": toUserDTO(user)})
+}
+
+func (e *Endpoint) findUser(c *gin.Context) (model.User, bool) {
+	var user model.User
+	if err := e.db.Where("id_external = ? AND deleted_at IS NULL", c.Param("id")).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		e.logger.Printf("Failed to find user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return user, false
+	}
+	return user, true
+}
+
+func toUserDTO(user model.User) UserDTO {
+	return UserDTO{
+		Id:              user.IdExternal.String(),
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		Email:           user.Email,
+		Role:            user.Role,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		TotpEnabled:     user.IsTotpEnabled(),
+		CreatedAt:       user.CreatedAt,
+	}
+}
@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Endpoint struct {
+	db     *gorm.DB
+	logger *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, logger: logger}
+}
+
+type FetchActivityRequest struct {
+	Page   int    `form:"page" binding:"required,min=1"`
+	Limit  int    `form:"limit" binding:"required,min=1,max=100"`
+	Action string `form:"action"`
+}
+
+type FetchAuditEventsRequest struct {
+	Page    int    `form:"page" binding:"required,min=1"`
+	Limit   int    `form:"limit" binding:"required,min=1,max=100"`
+	UserId  string `form:"userId" binding:"omitempty,uuid"`
+	ActorId string `form:"actorId" binding:"omitempty,uuid"`
+	Action  string `form:"action"`
+	Outcome string `form:"outcome" binding:"omitempty,oneof=success failure"`
+	Ip      string `form:"ip"`
+	// RFC 3339 timestamps bounding CreatedAt
+	From *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To   *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+type AuditEventDTO struct {
+	Id         string             `json:"id"`
+	Action     model.AuditAction  `json:"action"`
+	Outcome    model.AuditOutcome `json:"outcome"`
+	UserId     string             `json:"userId,omitempty"`
+	ActorId    string             `json:"actorId,omitempty"`
+	TargetType string             `json:"targetType,omitempty"`
+	TargetId   string             `json:"targetId,omitempty"`
+	IpAddress  string             `json:"ipAddress"`
+	UserAgent  string             `json:"userAgent"`
+	Metadata   json.RawMessage    `json:"metadata,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+}
+
+type FetchAuditEventsResponse struct {
+	Data  []AuditEventDTO `json:"data"`
+	Total int             `json:"total"`
+	Page  int             `json:"page"`
+	Limit int             `json:"limit"`
+}
+
+// FetchActivity godoc
+//
+//	@Summary		List account activity
+//	@Description	Lists security events concerning the authenticated user's account, newest first
+//	@Tags			users
+//	@Produce		json
+//	@Param			page	query		int							true	"Page number"
+//	@Param			limit	query		int							true	"Page size"
+//	@Param			action	query		string						false	"Action, e.g. auth.login"
+//	@Success		200		{object}	FetchAuditEventsResponse	"Activity"
+//	@Failure		400		{object}	map[string]interface{}		"Bad request"
+//	@Failure		401		{object}	map[string]interface{}		"Unauthorized"
+//	@Failure		500		{object}	map[string]interface{}		"Internal server error"
+//	@Router			/me/activity [get]
+func (e *Endpoint) FetchActivity(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request FetchActivityRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := e.db.Model(&model.AuditEvent{}).Where("id_user = ?", userId)
+	if request.Action != "" {
+		query = query.Where("action = ?", request.Action)
+	}
+
+	e.respondWithEvents(c, query, request.Page, request.Limit)
+}
+
+// FetchAuditEvents godoc
+//
+//	@Summary		Query the audit log
+//	@Description	Lists audit events across all users, newest first, with optional filters
+//	@Tags			admin
+//	@Produce		json
+//	@Param			page	query		int							true	"Page number"
+//	@Param			limit	query		int							true	"Page size"
+//	@Param			userId	query		string						false	"Account the event concerns"
+//	@Param			actorId	query		string						false	"User who performed the action"
+//	@Param			action	query		string						false	"Action, e.g. auth.login_failed"
+//	@Param			outcome	query		string						false	"success or failure"
+//	@Param			ip		query		string						false	"Client IP address"
+//	@Param			from	query		string						false	"Earliest time (RFC 3339)"
+//	@Param			to		query		string						false	"Latest time (RFC 3339)"
+//	@Success		200		{object}	FetchAuditEventsResponse	"Audit events"
+//	@Failure		400		{object}	map[string]interface{}		"Bad request"
+//	@Failure		403		{object}	map[string]interface{}		"Forbidden"
+//	@Failure		500		{object}	map[string]interface{}		"Internal server error"
+//	@Router			/admin/audit-events [get]
+func (e *Endpoint) FetchAuditEvents(c *gin.Context) {
+	var request FetchAuditEventsRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := e.db.Model(&model.AuditEvent{})
+	if request.UserId != "" {
+		query = query.Where("id_user IN (?)", e.db.Model(&model.User{}).Select("id_user").Where("id_external = ?", request.UserId))
+	}
+	if request.ActorId != "" {
+		query = query.Where("id_actor IN (?)", e.db.Model(&model.User{}).Select("id_user").Where("id_external = ?", request.ActorId))
+	}
+	if request.Action != "" {
+		query = query.Where("action = ?", request.Action)
+	}
+	if request.Outcome != "" {
+		query = query.Where("outcome = ?", request.Outcome)
+	}
+	if request.Ip != "" {
+		query = query.Where("ip_address = ?", request.Ip)
+	}
+	if request.From != nil {
+		query = query.Where("created_at >= ?", *request.From)
+	}
+	if request.To != nil {
+		query = query.Where("created_at <= ?", *request.To)
+	}
+
+	e.respondWithEvents(c, query, request.Page, request.Limit)
+}
+
+func (e *Endpoint) respondWithEvents(c *gin.Context, query *gorm.DB, page int, limit int) {
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		e.logger.Printf("Failed to count audit events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	var events []model.AuditEvent
+	if err := query.Order("created_at DESC, id_audit_event DESC").Limit(limit).Offset((page - 1) * limit).Find(&events).Error; err != nil {
+		e.logger.Printf("Failed to fetch audit events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	externalIds, err := e.externalUserIds(events)
+	if err != nil {
+		e.logger.Printf("Failed to resolve audit event users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	eventDTOs := make([]AuditEventDTO, 0, len(events))
+	for _, event := range events {
+		eventDTO := AuditEventDTO{
+			Id:         event.IdExternal.String(),
+			Action:     event.Action,
+			Outcome:    event.Outcome,
+			TargetType: event.TargetType,
+			TargetId:   event.TargetId,
+			IpAddress:  event.IpAddress,
+			UserAgent:  event.UserAgent,
+			CreatedAt:  event.CreatedAt,
+		}
+		if event.UserId != nil {
+			eventDTO.UserId = externalIds[*event.UserId]
+		}
+		if event.ActorId != nil {
+			eventDTO.ActorId = externalIds[*event.ActorId]
+		}
+		if event.Metadata != "" {
+			eventDTO.Metadata = json.RawMessage(event.Metadata)
+		}
+		eventDTOs = append(eventDTOs, eventDTO)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": FetchAuditEventsResponse{
+		Data:  eventDTOs,
+		Total: int(total),
+		Page:  page,
+		Limit: limit,
+	}})
+}
+
+// externalUserIds maps the internal user ids referenced by events to their
+// external ids. Purged users are simply missing from the map.
+func (e *Endpoint) externalUserIds(events []model.AuditEvent) (map[uint]string, error) {
+	ids := make([]uint, 0, len(events)*2)
+	for _, event := range events {
+		if event.UserId != nil {
+			ids = append(ids, *event.UserId)
+		}
+		if event.ActorId != nil {
+			ids = append(ids, *event.ActorId)
+		}
+	}
+
+	externalIds := make(map[uint]string, len(ids))
+	if len(ids) == 0 {
+		return externalIds, nil
+	}
+
+	var users []model.User
+	if err := e.db.Select("id_user", "id_external").Where("id_user IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		externalIds[user.IdUser] = user.IdExternal.String()
+	}
+	return externalIds, nil
+}
@@ -1,55 +1,117 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/consent"
+	"github.com/SomtoJF/iris-api/pkg/invite"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	"github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/SomtoJF/iris-api/pkg/session"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+const (
+	emailVerificationTokenTTL = 24 * time.Hour
+
+	verificationEmailLimit  = 3
+	verificationEmailWindow = time.Hour
+
+	// Wrong codes allowed per login challenge before it is discarded
+	loginChallengeAttempts = 5
+)
+
 type Endpoint struct {
 	DB           *gorm.DB
 	ClientDomain string
+	ClientUrl    string
+	Sessions     *session.Manager
+	Tokens       *onetimetoken.Store
+	Mailer       mailer.Mailer
+	RateLimiter  *redispubsub.RedisRateLimiter
+	LoginGuard   *redispubsub.RedisLoginGuard
+	MFA          *mfa.Service
+	Passwords    *passwordhash.Hasher
+	Audit        *audit.Recorder
+	Invites      *invite.Service
+	Consents     *consent.Service
 }
 
-func NewEndpoint(db *gorm.DB, clientDomain string) *Endpoint {
-	return &Endpoint{DB: db, ClientDomain: clientDomain}
+func NewEndpoint(db *gorm.DB, clientDomain string, clientUrl string, sessions *session.Manager, tokens *onetimetoken.Store, mailer mailer.Mailer, rateLimiter *redispubsub.RedisRateLimiter, loginGuard *redispubsub.RedisLoginGuard, mfaService *mfa.Service, passwords *passwordhash.Hasher, auditRecorder *audit.Recorder, invites *invite.Service, consents *consent.Service) *Endpoint {
+	return &Endpoint{
+		DB:           db,
+		ClientDomain: clientDomain,
+		ClientUrl:    clientUrl,
+		Sessions:     sessions,
+		Tokens:       tokens,
+		Mailer:       mailer,
+		RateLimiter:  rateLimiter,
+		LoginGuard:   loginGuard,
+		MFA:          mfaService,
+		Passwords:    passwords,
+		Audit:        auditRecorder,
+		Invites:      invites,
+		Consents:     consents,
+	}
 }
 
 type signUpInput struct {
 	FirstName string `json:"firstName" binding:"required,max=50"`
 	LastName  string `json:"lastName" binding:"required,max=50"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,max=20,min=8"`
+	Password  string `json:"password" binding:"required"`
+	// Required while signup is invite-only
+	InviteCode string `json:"inviteCode"`
+	// Ids of the current legal documents, from GET /legal/documents, the user accepted
+	AcceptedDocuments []string `json:"acceptedDocuments"`
 }
 
 type loginInput struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,max=20,min=8"`
+	Password string `json:"password" binding:"required,max=256"`
+}
+
+type loginMFAInput struct {
+	Challenge string `json:"challenge" binding:"required"`
+	// Either a code from the authenticator app or a recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type verifyEmailInput struct {
+	Token string `json:"token" binding:"required"`
 }
 
 type passwordResetRequest struct {
-	Password    string `json:"password" binding:"required,max=20"`
-	NewPassword string `json:"newPassword" binding:"required,max=20"`
+	Password    string `json:"password" binding:"required,max=256"`
+	NewPassword string `json:"newPassword" binding:"required"`
 }
 
 // Login godoc
 //
 //	@Summary		Login user
-//	@Description	Logs in a user and returns an access token
+//	@Description	Logs in a user and returns an access token, or a challenge to redeem at /login/mfa when two-factor authentication is enabled
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			loginInput	body		loginInput				true	"Login credentials"
 //	@Success		200			{object}	map[string]interface{}	"success message"
 //	@Failure		400			{object}	map[string]interface{}	"error message"
+//	@Failure		429			{object}	map[string]interface{}	"Locked out after too many failed attempts"
 //	@Failure		500			{object}	map[string]interface{}	"internal server error"
 //	@Router			/login [post]
 func (e *Endpoint) Login(c *gin.Context) {
@@ -60,70 +122,176 @@ func (e *Endpoint) Login(c *gin.Context) {
 		return
 	}
 
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if e.rejectIfLockedOut(c, email) {
+		return
+	}
+
 	var userFound model.User
-	e.DB.Where("email=?", body.Email).Find(&userFound)
+	e.DB.Where("LOWER(email) = ? AND deleted_at IS NULL", email).Find(&userFound)
 
 	if userFound.IdUser == 0 {
+		if lockedFor := e.recordLoginFailure(c, email, nil); lockedFor > 0 {
+			respondLockedOut(c, lockedFor)
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "email or password is incorrect"})
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(userFound.PasswordHash), []byte(body.Password)); err != nil {
+	match, needsRehash, err := e.Passwords.Verify(body.Password, userFound.PasswordHash)
+	if err != nil {
+		log.Printf("Failed to verify password: %v", err)
+	}
+	if !match {
+		if lockedFor := e.recordLoginFailure(c, email, &userFound); lockedFor > 0 {
+			respondLockedOut(c, lockedFor)
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "email or password is incorrect"})
 		return
 	}
 
-	generateToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":    userFound.IdExternal.String(),
-		"email": userFound.Email,
-		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(),
-	})
+	e.resetLoginFailures(c.Request.Context(), email)
 
-	token, err := generateToken.SignedString([]byte(os.Getenv("SECRET")))
+	if needsRehash {
+		e.rehashPassword(userFound, body.Password)
+	}
 
+	e.completeLogin(c, userFound, "password")
+}
+
+// LoginWithMFA godoc
+//
+//	@Summary		Complete two-factor login
+//	@Description	Redeems a login challenge with an authenticator or recovery code and starts a session
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			loginMFAInput	body		loginMFAInput			true	"Login challenge and code"
+//	@Success		200				{object}	map[string]interface{}	"success message"
+//	@Failure		400				{object}	map[string]interface{}	"error message"
+//	@Failure		429				{object}	map[string]interface{}	"Too many attempts"
+//	@Failure		500				{object}	map[string]interface{}	"internal server error"
+//	@Failure		503				{object}	map[string]interface{}	"Rate limiter unavailable"
+//	@Router			/login/mfa [post]
+func (e *Endpoint) LoginWithMFA(c *gin.Context) {
+	var body loginMFAInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, err := e.Tokens.Lookup(body.Challenge, model.UserTokenPurposeLoginChallenge)
 	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Login challenge is invalid or has expired, please login again"})
+			return
+		}
+		log.Printf("Failed to look up login challenge: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
 	}
 
-	var secure bool
-	sameSite := http.SameSiteDefaultMode
-	domain := e.ClientDomain
+	// The limit is all that stops a 6 digit code being guessed, so codes are
+	// not checked at all while it cannot be enforced
+	allowed, _, err := e.RateLimiter.Allow(c.Request.Context(), "login-challenge", challenge.TokenHash, loginChallengeAttempts, mfa.LoginChallengeTTL)
+	if err != nil {
+		log.Printf("Failed to check rate limit: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor login is temporarily unavailable, please try again later"})
+		return
+	}
+	if !allowed {
+		// Burn the challenge so the password has to be entered again
+		if _, err := e.Tokens.Consume(body.Challenge, model.UserTokenPurposeLoginChallenge); err != nil && !errors.Is(err, onetimetoken.ErrInvalidToken) {
+			log.Printf("Failed to burn login challenge: %v", err)
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many incorrect codes, please login again"})
+		return
+	}
 
-	if !strings.Contains(domain, "localhost") {
-		secure = true
-		sameSite = http.SameSiteNoneMode
+	var userFound model.User
+	if err := e.DB.Where("id_user = ? AND deleted_at IS NULL", challenge.UserId).First(&userFound).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login challenge is invalid or has expired, please login again"})
+		return
 	}
 
-	// For debugging
-	fmt.Printf("Setting cookie with domain: %s, secure: %v, sameSite: %v\n", domain, secure, sameSite)
+	if err := e.MFA.Verify(userFound, body.Code); err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, mfa.ErrNotEnabled) {
+			e.Audit.Record(c, audit.Event{
+				Action:   model.AuditActionLoginFailed,
+				Outcome:  model.AuditOutcomeFailure,
+				UserId:   audit.UserId(userFound.IdUser),
+				Metadata: map[string]interface{}{"factor": "totp"},
+			})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication code is incorrect"})
+			return
+		}
+		log.Printf("Failed to verify authentication code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
 
-	cookie := &http.Cookie{
-		Name:     "Access_Token",
-		Value:    token,
-		Path:     "/",
-		Domain:   domain,
-		MaxAge:   604800,
-		Secure:   secure,
-		HttpOnly: true,
-		SameSite: sameSite,
+	// Consuming after verification means a concurrent request can't reuse it
+	if _, err := e.Tokens.Consume(body.Challenge, model.UserTokenPurposeLoginChallenge); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login challenge is invalid or has expired, please login again"})
+		return
 	}
-	http.SetCookie(c.Writer, cookie)
 
-	c.JSON(200, gin.H{
-		"message": "success",
-	})
+	newSession, err := e.Sessions.Start(c, userFound)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+
+	// The challenge records how the first factor was proven
+	firstFactor := challenge.Payload
+	if firstFactor == "" {
+		firstFactor = "password"
+	}
+	e.recordLogin(c, userFound, newSession, firstFactor+"+totp")
+
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// Refresh godoc
+//
+//	@Summary		Refresh access token
+//	@Description	Rotates the refresh token cookie and issues a new access token
+//	@Tags			auth
+//	@Success		200	{object}	map[string]interface{}	"success message"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"internal server error"
+//	@Router			/refresh [post]
+func (e *Endpoint) Refresh(c *gin.Context) {
+	if _, err := e.Sessions.Refresh(c); err != nil {
+		if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrRefreshTokenReused) || errors.Is(err, session.ErrSessionInactive) {
+			e.Sessions.ClearCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please login again"})
+			return
+		}
+		log.Printf("Failed to refresh session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
 }
 
 // Signup godoc
 //
 //	@Summary		Signup a new user
-//	@Description	Creates a new user account
+//	@Description	Creates a new user account, records acceptance of the current legal documents and emails a link to verify the address
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			userInput	body		signUpInput				true	"User details"
 //	@Success		201			{object}	map[string]interface{}	"Account created successfully"
-//	@Failure		400			{object}	map[string]interface{}	"Bad request"
+//	@Failure		400			{object}	map[string]interface{}	"Bad request or current terms not accepted"
+//	@Failure		403			{object}	map[string]interface{}	"Invite code required or invalid"
+//	@Failure		429			{object}	map[string]interface{}	"Too many signups"
 //	@Failure		500			{object}	map[string]interface{}	"Internal server error"
 //	@Router			/signup [post]
 func (e *Endpoint) Signup(c *gin.Context) {
@@ -134,6 +302,10 @@ func (e *Endpoint) Signup(c *gin.Context) {
 		return
 	}
 
+	if !e.allowSignup(c) {
+		return
+	}
+
 	var userFound model.User
 	e.DB.Where("email=?", body.Email).Find(&userFound)
 
@@ -142,59 +314,212 @@ func (e *Endpoint) Signup(c *gin.Context) {
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	if err != nil {
+	if err := e.Passwords.Validate(body.Password); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	acceptedDocuments, missingDocuments, err := e.Consents.Resolve(body.AcceptedDocuments)
+	if err != nil && !errors.Is(err, consent.ErrUnknownDocument) {
+		log.Printf("Failed to resolve accepted legal documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+	if err != nil || len(missingDocuments) > 0 {
+		current, _ := e.Consents.Current()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "You must accept the current terms to create an account",
+			"code":      "CONSENT_REQUIRED",
+			"documents": consent.ToDTOs(current),
+		})
+		return
+	}
+
+	passwordHash, err := e.Passwords.Hash(body.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
 	user := model.User{
 		Email:        body.Email,
 		FirstName:    body.FirstName,
 		LastName:     body.LastName,
-		PasswordHash: string(passwordHash),
+		PasswordHash: passwordHash,
 	}
 
-	if err := e.DB.Create(&user).Error; err != nil {
+	var redeemed *model.Invite
+	err = e.DB.Transaction(func(tx *gorm.DB) error {
+		redeemed, err = e.Invites.Redeem(tx, body.InviteCode)
+		if err != nil {
+			return err
+		}
+		if redeemed != nil {
+			user.InviteId = &redeemed.IdInvite
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := e.Consents.Accept(tx, user.IdUser, acceptedDocuments, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			return err
+		}
+		return tx.Model(&model.WaitlistEntry{}).
+			Where("LOWER(email) = ? AND signed_up_at IS NULL", strings.ToLower(user.Email)).
+			Update("signed_up_at", time.Now()).Error
+	})
+	if err != nil {
+		if errors.Is(err, invite.ErrInviteRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Signup is currently invite-only, join the waitlist to get access", "code": "INVITE_REQUIRED"})
+			return
+		}
+		if errors.Is(err, invite.ErrInvalidCode) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invite code is invalid, expired or already used", "code": "INVITE_INVALID"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error creating user: %s", err)})
 		return
 	}
 
+	signupEvent := audit.Event{
+		Action:  model.AuditActionSignup,
+		Outcome: model.AuditOutcomeSuccess,
+		UserId:  audit.UserId(user.IdUser),
+	}
+	if redeemed != nil {
+		signupEvent.Metadata = map[string]interface{}{"invite": redeemed.IdExternal.String()}
+	}
+	e.Audit.Record(c, signupEvent)
+
+	// The account exists at this point, so a mail failure must not fail signup;
+	// the user can ask for another link.
+	if err := e.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "account created successfully",
 		"data":    body,
 	})
 }
 
+// VerifyEmail godoc
+//
+//	@Summary		Verify email address
+//	@Description	Marks the user's email as verified using the token from the verification email
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			verifyEmailInput	body		verifyEmailInput		true	"Verification token"
+//	@Success		200					{object}	map[string]interface{}	"Email verified"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/email/verify [post]
+func (e *Endpoint) VerifyEmail(c *gin.Context) {
+	var body verifyEmailInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userToken, err := e.Tokens.Consume(body.Token, model.UserTokenPurposeEmailVerification)
+	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+			return
+		}
+		log.Printf("Failed to consume email verification token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// The token carries the address it was sent to, so a link for an address
+	// the user has since changed away from cannot verify the new one.
+	result := e.DB.Model(&model.User{}).
+		Where("id_user = ? AND email = ? AND deleted_at IS NULL", userToken.UserId, userToken.Payload).
+		Update("email_verified_at", time.Now())
+	if result.Error != nil {
+		log.Printf("Failed to mark email as verified: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		return
+	}
+
+	e.Audit.Record(c, audit.Event{
+		Action:  model.AuditActionEmailVerified,
+		Outcome: model.AuditOutcomeSuccess,
+		UserId:  audit.UserId(userToken.UserId),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail godoc
+//
+//	@Summary		Resend verification email
+//	@Description	Sends a new email verification link to the authenticated user
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Verification email sent"
+//	@Failure		400	{object}	map[string]interface{}	"Email already verified"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		429	{object}	map[string]interface{}	"Too many requests"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/email/verify/resend [post]
+func (e *Endpoint) ResendVerificationEmail(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if user.IsEmailVerified() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	allowed, retryAfter, err := e.RateLimiter.Allow(c.Request.Context(), "verification-email:user", user.IdExternal.String(), verificationEmailLimit, verificationEmailWindow)
+	if err != nil {
+		log.Printf("Failed to check rate limit: %v", err)
+	} else if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails requested, please try again later"})
+		return
+	}
+
+	if err := e.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
 // Logout godoc
 //
 //	@Summary		Logout user
-//	@Description	Logs out the user by clearing the access token
+//	@Description	Logs out the user by revoking the current session and clearing its cookies
 //	@Tags			auth
 //	@Success		200	{object}	map[string]interface{}	"Logout successful"
 //	@Router			/logout [post]
 func (e *Endpoint) Logout(c *gin.Context) {
-	// Set cookie with all parameters to ensure proper deletion
-	var secure bool
-	sameSite := http.SameSiteDefaultMode
-	domain := e.ClientDomain
-
-	if !strings.Contains(domain, "localhost") {
-		secure = true
-		sameSite = http.SameSiteNoneMode
+	if err := e.Sessions.Revoke(c.GetUint("sessionId"), model.SessionRevokedLogout); err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
 	}
 
-	cookie := &http.Cookie{
-		Name:     "Access_Token",
-		Value:    "",
-		Path:     "/",
-		Domain:   domain,
-		MaxAge:   -1,
-		Secure:   secure,
-		HttpOnly: true,
-		SameSite: sameSite,
-	}
-	http.SetCookie(c.Writer, cookie)
+	e.Sessions.ClearCookies(c)
+
+	e.Audit.Record(c, audit.Event{
+		Action:  model.AuditActionLogout,
+		Outcome: model.AuditOutcomeSuccess,
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "logout successful"})
 }
@@ -202,7 +527,7 @@ func (e *Endpoint) Logout(c *gin.Context) {
 // ResetPassword godoc
 //
 //	@Summary		Reset user password
-//	@Description	Resets the password for the authenticated user
+//	@Description	Resets the password for the authenticated user and signs out their other sessions
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
@@ -228,33 +553,51 @@ func (e *Endpoint) ResetPassword(c *gin.Context) {
 	}
 
 	// Verify the current password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)); err != nil {
+	if match, _, err := e.Passwords.Verify(body.Password, user.PasswordHash); !match {
+		if err != nil {
+			log.Printf("Failed to verify password: %v", err)
+		}
+		e.Audit.Record(c, audit.Event{
+			Action:   model.AuditActionPasswordChange,
+			Outcome:  model.AuditOutcomeFailure,
+			Metadata: map[string]interface{}{"reason": "incorrect_password"},
+		})
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
 		return
 	}
 
+	if err := e.Passwords.Validate(body.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Hash the new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	hashedPassword, err := e.Passwords.Hash(body.NewPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash new password"})
 		return
 	}
 
 	// Update the password in the database
-	user.PasswordHash = string(hashedPassword)
+	user.PasswordHash = hashedPassword
 	if err := e.DB.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
 
-	var secure bool
-	domain := e.ClientDomain
+	// Sign out every other device; the session making the change stays valid
+	if err := e.Sessions.RevokeAllForUser(user.IdUser, c.GetUint("sessionId"), model.SessionRevokedPasswordChange); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out other sessions"})
+		return
+	}
 
-	// Strip any protocol prefix from domain
-	domain = strings.TrimPrefix(domain, "http://")
-	domain = strings.TrimPrefix(domain, "https://")
-	c.SetCookie("Access_Token", "", -1, "/", domain, secure, true)
-	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully. Please login with new password"})
+	e.Audit.Record(c, audit.Event{
+		Action:  model.AuditActionPasswordChange,
+		Outcome: model.AuditOutcomeSuccess,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully. Other sessions have been signed out"})
 }
 
 // GetCurrentUser godoc
@@ -271,5 +614,96 @@ func (e *Endpoint) GetCurrentUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "We couldn't retrieve your data"})
 		return
 	}
+
+	// Lets the client show that an admin is viewing the account
+	if impersonator, ok := c.Value("impersonator").(model.User); ok {
+		c.JSON(http.StatusOK, gin.H{
+			"data": user,
+			"impersonatedBy": gin.H{
+				"id":    impersonator.IdExternal.String(),
+				"email": impersonator.Email,
+			},
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
+
+func (e *Endpoint) sendVerificationEmail(ctx context.Context, user model.User) error {
+	token, err := e.Tokens.Issue(user.IdUser, model.UserTokenPurposeEmailVerification, emailVerificationTokenTTL, user.Email)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimSuffix(e.ClientUrl, "/"), url.QueryEscape(token))
+
+	return e.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Iris email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			user.FirstName, link, int(emailVerificationTokenTTL.Hours())),
+	})
+}
+
+// rehashPassword upgrades a hash made with an outdated algorithm or cost
+// after a successful login. Losing a race with a password change is fine, the
+// conditional update then leaves the newer hash alone.
+func (e *Endpoint) rehashPassword(user model.User, password string) {
+	passwordHash, err := e.Passwords.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password: %v", err)
+		return
+	}
+
+	err = e.DB.Model(&model.User{}).
+		Where("id_user = ? AND password_hash = ?", user.IdUser, user.PasswordHash).
+		Update("password_hash", passwordHash).Error
+	if err != nil {
+		log.Printf("Failed to store rehashed password: %v", err)
+	}
+}
+
+// completeLogin finishes a login once the first factor, proven with method,
+// has been verified. Users with two-factor authentication get a short-lived
+// challenge to redeem at /login/mfa instead of a session.
+func (e *Endpoint) completeLogin(c *gin.Context, user model.User, method string) {
+	if user.IsTotpEnabled() {
+		challenge, err := e.Tokens.Issue(user.IdUser, model.UserTokenPurposeLoginChallenge, mfa.LoginChallengeTTL, method)
+		if err != nil {
+			log.Printf("Failed to issue login challenge: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "two-factor authentication required",
+			"mfaRequired": true,
+			"challenge":   challenge,
+		})
+		return
+	}
+
+	newSession, err := e.Sessions.Start(c, user)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+
+	e.recordLogin(c, user, newSession, method)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+	})
+}
+
+func (e *Endpoint) recordLogin(c *gin.Context, user model.User, newSession *model.Session, method string) {
+	e.Audit.Record(c, audit.Event{
+		Action:     model.AuditActionLogin,
+		Outcome:    model.AuditOutcomeSuccess,
+		UserId:     audit.UserId(user.IdUser),
+		TargetType: "session",
+		TargetId:   newSession.IdExternal.String(),
+		Metadata:   map[string]interface{}{"method": method},
+	})
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	unlockTokenTTL = 24 * time.Hour
+
+	unlockEmailLimit  = 3
+	unlockEmailWindow = time.Hour
+
+	signupIPLimit  = 5
+	signupIPWindow = time.Hour
+)
+
+var (
+	accountLockoutPolicy = redispubsub.LockoutPolicy{
+		Threshold:     5,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		FailureWindow: 24 * time.Hour,
+	}
+	// IPs get more headroom since many users can share one address
+	ipLockoutPolicy = redispubsub.LockoutPolicy{
+		Threshold:     20,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		FailureWindow: time.Hour,
+	}
+)
+
+type unlockAccountInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// UnlockAccount godoc
+//
+//	@Summary		Unlock account
+//	@Description	Lifts a login lockout using the token from the unlock email
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			unlockAccountInput	body		unlockAccountInput		true	"Unlock token"
+//	@Success		200					{object}	map[string]interface{}	"Account unlocked"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/account/unlock [post]
+func (e *Endpoint) UnlockAccount(c *gin.Context) {
+	var body unlockAccountInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userToken, err := e.Tokens.Consume(body.Token, model.UserTokenPurposeAccountUnlock)
+	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unlock link is invalid or has expired"})
+			return
+		}
+		log.Printf("Failed to consume unlock token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	if err := e.unlockAccount(c.Request.Context(), userToken.Payload); err != nil {
+		log.Printf("Failed to unlock account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	e.Audit.Record(c, audit.Event{
+		Action:  model.AuditActionAccountUnlocked,
+		Outcome: model.AuditOutcomeSuccess,
+		UserId:  audit.UserId(userToken.UserId),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked, you can login again"})
+}
+
+// rejectIfLockedOut writes a 429 response when either the client IP or the
+// account is locked out
+func (e *Endpoint) rejectIfLockedOut(c *gin.Context, email string) bool {
+	ctx := c.Request.Context()
+
+	var lockedFor time.Duration
+	for scope, identifier := range map[model.LockoutScope]string{
+		model.LockoutScopeIP:      c.ClientIP(),
+		model.LockoutScopeAccount: email,
+	} {
+		remaining, err := e.LoginGuard.LockedFor(ctx, string(scope), identifier)
+		if err != nil {
+			// Fail open so a Redis outage does not lock everyone out
+			log.Printf("Failed to check login lockout: %v", err)
+			continue
+		}
+		lockedFor = max(lockedFor, remaining)
+	}
+
+	if lockedFor > 0 {
+		e.Audit.Record(c, audit.Event{
+			Action:  model.AuditActionLoginBlocked,
+			Outcome: model.AuditOutcomeFailure,
+		})
+		respondLockedOut(c, lockedFor)
+		return true
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed login against the client IP and the
+// account and returns the longest lockout it triggered, if any. user is nil
+// when no account exists for the email.
+func (e *Endpoint) recordLoginFailure(c *gin.Context, email string, user *model.User) time.Duration {
+	ctx := c.Request.Context()
+
+	var userId *uint
+	if user != nil {
+		userId = audit.UserId(user.IdUser)
+	}
+	e.Audit.Record(c, audit.Event{
+		Action:   model.AuditActionLoginFailed,
+		Outcome:  model.AuditOutcomeFailure,
+		UserId:   userId,
+		Metadata: map[string]interface{}{"factor": "password"},
+	})
+
+	var lockedFor time.Duration
+	for _, attempt := range []struct {
+		scope      model.LockoutScope
+		identifier string
+		policy     redispubsub.LockoutPolicy
+	}{
+		{model.LockoutScopeIP, c.ClientIP(), ipLockoutPolicy},
+		{model.LockoutScopeAccount, email, accountLockoutPolicy},
+	} {
+		failures, lockout, err := e.LoginGuard.RecordFailure(ctx, string(attempt.scope), attempt.identifier, attempt.policy)
+		if err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+			continue
+		}
+		if lockout == 0 {
+			continue
+		}
+		lockedFor = max(lockedFor, lockout)
+
+		lockoutRecord := model.AccountLockout{
+			Scope:       attempt.scope,
+			Identifier:  attempt.identifier,
+			IpAddress:   c.ClientIP(),
+			UserAgent:   c.Request.UserAgent(),
+			Failures:    failures,
+			LockedUntil: time.Now().Add(lockout),
+		}
+		lockoutRecord.UserId = userId
+		if err := e.DB.Create(&lockoutRecord).Error; err != nil {
+			log.Printf("Failed to record account lockout: %v", err)
+		}
+
+		metadata := map[string]interface{}{
+			"scope":       attempt.scope,
+			"failures":    failures,
+			"lockedUntil": lockoutRecord.LockedUntil,
+		}
+		// Account lockouts are keyed by email, which stays out of the audit log
+		if attempt.scope == model.LockoutScopeIP {
+			metadata["identifier"] = attempt.identifier
+		}
+		e.Audit.Record(c, audit.Event{
+			Action:   model.AuditActionAccountLocked,
+			Outcome:  model.AuditOutcomeSuccess,
+			UserId:   userId,
+			Metadata: metadata,
+		})
+
+		if attempt.scope == model.LockoutScopeAccount && user != nil {
+			if err := e.sendUnlockEmail(ctx, *user, email, lockout); err != nil {
+				log.Printf("Failed to send unlock email: %v", err)
+			}
+		}
+	}
+
+	return lockedFor
+}
+
+// resetLoginFailures forgets the failed attempts against an account after a
+// successful login. IP failures are kept so one good login cannot be used to
+// keep guessing other accounts.
+func (e *Endpoint) resetLoginFailures(ctx context.Context, email string) {
+	if err := e.LoginGuard.Reset(ctx, string(model.LockoutScopeAccount), email); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+}
+
+func (e *Endpoint) unlockAccount(ctx context.Context, email string) error {
+	if err := e.LoginGuard.Reset(ctx, string(model.LockoutScopeAccount), email); err != nil {
+		return err
+	}
+	return e.DB.Model(&model.AccountLockout{}).
+		Where("scope = ? AND identifier = ? AND unlocked_at IS NULL", model.LockoutScopeAccount, email).
+		Update("unlocked_at", time.Now()).Error
+}
+
+func (e *Endpoint) sendUnlockEmail(ctx context.Context, user model.User, email string, lockout time.Duration) error {
+	allowed, _, err := e.RateLimiter.Allow(ctx, "unlock-email", email, unlockEmailLimit, unlockEmailWindow)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	token, err := e.Tokens.Issue(user.IdUser, model.UserTokenPurposeAccountUnlock, unlockTokenTTL, email)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/unlock-account?token=%s", strings.TrimSuffix(e.ClientUrl, "/"), url.QueryEscape(token))
+
+	return e.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Iris account has been temporarily locked",
+		Body: fmt.Sprintf("Hi %s,\n\nWe locked your account for %s after several failed login attempts.\n\nIf this was you, you can unlock it right away:\n\n%s\n\nIf it was not you, consider resetting your password.\n",
+			user.FirstName, lockout.Round(time.Second), link),
+	})
+}
+
+// allowSignup applies the per-IP signup limit and writes a 429 response when it is exceeded
+func (e *Endpoint) allowSignup(c *gin.Context) bool {
+	allowed, retryAfter, err := e.RateLimiter.Allow(c.Request.Context(), "signup:ip", c.ClientIP(), signupIPLimit, signupIPWindow)
+	if err != nil {
+		log.Printf("Failed to check rate limit: %v", err)
+		return true
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many accounts created from this network, please try again later"})
+		return false
+	}
+	return true
+}
+
+func respondLockedOut(c *gin.Context, lockedFor time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please try again later"})
+}
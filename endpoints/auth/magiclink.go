@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/accountclaim"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	magicLinkTTL = 15 * time.Minute
+
+	magicLinkEmailLimit = 3
+	magicLinkIPLimit    = 10
+	magicLinkWindow     = time.Hour
+)
+
+type magicLinkRequestInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type magicLinkVerifyInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestMagicLink godoc
+//
+//	@Summary		Request a sign-in link
+//	@Description	Emails a single-use sign-in link if an account exists for the address
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			magicLinkRequestInput	body		magicLinkRequestInput	true	"Account email"
+//	@Success		200						{object}	map[string]interface{}	"Sign-in link sent if the account exists"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		429						{object}	map[string]interface{}	"Too many requests"
+//	@Router			/login/magic-link [post]
+func (e *Endpoint) RequestMagicLink(c *gin.Context) {
+	var body magicLinkRequestInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+
+	if !e.allowMagicLink(c, "magic-link:ip", c.ClientIP(), magicLinkIPLimit) {
+		return
+	}
+	if !e.allowMagicLink(c, "magic-link:email", email, magicLinkEmailLimit) {
+		return
+	}
+
+	// Always respond the same way so the endpoint cannot be used to discover accounts
+	response := gin.H{"message": "If an account exists for this email, a sign-in link has been sent"}
+
+	var user model.User
+	if err := e.DB.Where("LOWER(email) = ? AND deleted_at IS NULL", email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to look up user for magic link: %v", err)
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// A delivery failure is only logged, as an error here would reveal that
+	// the account exists
+	if err := e.sendMagicLink(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send magic link: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// VerifyMagicLink godoc
+//
+//	@Summary		Sign in with a magic link
+//	@Description	Redeems a sign-in link and starts a session, or returns a challenge to redeem at /login/mfa when two-factor authentication is enabled
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			magicLinkVerifyInput	body		magicLinkVerifyInput	true	"Sign-in token"
+//	@Success		200						{object}	map[string]interface{}	"success message"
+//	@Failure		400						{object}	map[string]interface{}	"Invalid or expired link"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/login/magic-link/verify [post]
+func (e *Endpoint) VerifyMagicLink(c *gin.Context) {
+	var body magicLinkVerifyInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userToken, err := e.Tokens.Consume(body.Token, model.UserTokenPurposeMagicLink)
+	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			e.Audit.Record(c, audit.Event{
+				Action:   model.AuditActionLoginFailed,
+				Outcome:  model.AuditOutcomeFailure,
+				Metadata: map[string]interface{}{"method": "magic_link", "reason": "invalid_token"},
+			})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in link is invalid, has expired or was already used"})
+			return
+		}
+		log.Printf("Failed to consume magic link token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+
+	// The token carries the address it was sent to, so a link sent before an
+	// email change stops working once the address changes
+	var userFound model.User
+	if err := e.DB.Where("id_user = ? AND email = ? AND deleted_at IS NULL", userToken.UserId, userToken.Payload).First(&userFound).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to find user for magic link: %v", err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in link is invalid, has expired or was already used"})
+		return
+	}
+
+	// Following the link proves ownership of the address, so an account that
+	// was never verified is claimed from whoever registered it
+	if !userFound.IsEmailVerified() {
+		var claimed bool
+		err := e.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			claimed, err = accountclaim.Claim(tx, &userFound, time.Now())
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to claim account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+			return
+		}
+		if claimed {
+			if err := e.Sessions.RevokeAllForUser(userFound.IdUser, 0, model.SessionRevokedAccountClaimed); err != nil {
+				log.Printf("Failed to revoke sessions of claimed account: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+				return
+			}
+		}
+	}
+	e.resetLoginFailures(c.Request.Context(), strings.ToLower(userFound.Email))
+
+	e.completeLogin(c, userFound, "magic_link")
+}
+
+func (e *Endpoint) sendMagicLink(ctx context.Context, user model.User) error {
+	token, err := e.Tokens.Issue(user.IdUser, model.UserTokenPurposeMagicLink, magicLinkTTL, user.Email)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/magic-link?token=%s", strings.TrimSuffix(e.ClientUrl, "/"), url.QueryEscape(token))
+
+	return e.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Iris sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to sign in to Iris:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you did not request this, you can ignore this email.\n",
+			user.FirstName, link, int(magicLinkTTL.Minutes())),
+	})
+}
+
+// allowMagicLink applies a magic link rate limit and writes a 429 response when it is exceeded
+func (e *Endpoint) allowMagicLink(c *gin.Context, scope string, identifier string, limit int) bool {
+	allowed, retryAfter, err := e.RateLimiter.Allow(c.Request.Context(), scope, identifier, limit, magicLinkWindow)
+	if err != nil {
+		log.Printf("Failed to check rate limit: %v", err)
+		return true
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many sign-in links requested, please try again later"})
+		return false
+	}
+	return true
+}
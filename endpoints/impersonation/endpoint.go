@@ -0,0 +1,154 @@
+package impersonation
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Endpoint struct {
+	db       *gorm.DB
+	sessions *session.Manager
+	audit    *audit.Recorder
+	logger   *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, sessions *session.Manager, auditRecorder *audit.Recorder, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, sessions: sessions, audit: auditRecorder, logger: logger}
+}
+
+type StartImpersonationRequest struct {
+	// Why support needs to see the account, e.g. a ticket reference
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// StartImpersonation godoc
+//
+//	@Summary		Impersonate a user
+//	@Description	Replaces the admin's session cookies with a read-only session acting as the user for up to an hour. Every request made with it is audited.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id							path		string						true	"User id"
+//	@Param			startImpersonationRequest	body		StartImpersonationRequest	true	"Reason for impersonating"
+//	@Success		200							{object}	map[string]interface{}		"Impersonation started"
+//	@Failure		400							{object}	map[string]interface{}		"Bad request"
+//	@Failure		403							{object}	map[string]interface{}		"Forbidden"
+//	@Failure		404							{object}	map[string]interface{}		"Not found"
+//	@Failure		500							{object}	map[string]interface{}		"Internal server error"
+//	@Router			/admin/users/{id}/impersonate [post]
+func (e *Endpoint) StartImpersonation(c *gin.Context) {
+	admin, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request StartImpersonationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target model.User
+	if err := e.db.Where("id_external = ? AND deleted_at IS NULL", c.Param("id")).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+
+	if target.IdUser == admin.IdUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot impersonate yourself"})
+		return
+	}
+	// Impersonating another admin would let one admin act with another's privileges
+	if target.HasPermission(model.PermissionUsersImpersonate) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrators cannot be impersonated"})
+		return
+	}
+
+	reason := strings.TrimSpace(request.Reason)
+	newSession, err := e.sessions.StartImpersonation(c, target, admin, reason)
+	if err != nil {
+		e.logger.Printf("Failed to start impersonation session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionImpersonationStarted,
+		Outcome:    model.AuditOutcomeSuccess,
+		UserId:     audit.UserId(target.IdUser),
+		TargetType: "session",
+		TargetId:   newSession.IdExternal.String(),
+		Metadata:   map[string]interface{}{"reason": reason},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Impersonation started",
+		"data": gin.H{
+			"userId":    target.IdExternal.String(),
+			"email":     target.Email,
+			"expiresAt": newSession.ExpiresAt,
+		},
+	})
+}
+
+// StopImpersonation godoc
+//
+//	@Summary		Stop impersonating
+//	@Description	Ends the impersonation session and signs the admin back in as themselves
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Impersonation ended"
+//	@Failure		400	{object}	map[string]interface{}	"Not impersonating"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/impersonation/stop [post]
+func (e *Endpoint) StopImpersonation(c *gin.Context) {
+	admin, ok := c.Value("impersonator").(model.User)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You are not impersonating anyone"})
+		return
+	}
+
+	sessionId := c.GetUint("sessionId")
+	startedAt := time.Now()
+	if currentSession, ok := c.Value("session").(*model.Session); ok {
+		startedAt = currentSession.CreatedAt
+	}
+
+	if err := e.sessions.Revoke(sessionId, model.SessionRevokedImpersonation); err != nil {
+		e.logger.Printf("Failed to end impersonation session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop impersonation"})
+		return
+	}
+
+	// Recorded before the admin's own session starts so the event is still
+	// attributed to the impersonation
+	e.audit.Record(c, audit.Event{
+		Action:   model.AuditActionImpersonationEnded,
+		Outcome:  model.AuditOutcomeSuccess,
+		Metadata: map[string]interface{}{"durationSeconds": int(time.Since(startedAt).Seconds())},
+	})
+
+	if _, err := e.sessions.Start(c, admin); err != nil {
+		e.logger.Printf("Failed to restore admin session: %v", err)
+		e.sessions.ClearCookies(c)
+		c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended, please login again"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
@@ -0,0 +1,433 @@
+package invite
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/invite"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	waitlistIPLimit  = 5
+	waitlistIPWindow = time.Hour
+
+	// Invites sent to waitlist entries are single use and expire after this
+	waitlistInviteTTL = 14 * 24 * time.Hour
+)
+
+type Endpoint struct {
+	db          *gorm.DB
+	invites     *invite.Service
+	mailer      mailer.Mailer
+	rateLimiter *redispubsub.RedisRateLimiter
+	logger      *log.Logger
+	clientUrl   string
+}
+
+func NewEndpoint(db *gorm.DB, invites *invite.Service, mailer mailer.Mailer, rateLimiter *redispubsub.RedisRateLimiter, logger *log.Logger, clientUrl string) *Endpoint {
+	return &Endpoint{db: db, invites: invites, mailer: mailer, rateLimiter: rateLimiter, logger: logger, clientUrl: clientUrl}
+}
+
+type JoinWaitlistRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Note  string `json:"note" binding:"max=500"`
+}
+
+type CreateInviteRequest struct {
+	MaxUses int    `json:"maxUses" binding:"omitempty,min=1,max=1000"`
+	Note    string `json:"note" binding:"max=255"`
+	// When set, the code is emailed to this address
+	Email string `json:"email" binding:"omitempty,email"`
+	// Omit for a code that never expires
+	ExpiresInDays *int `json:"expiresInDays" binding:"omitempty,min=1,max=365"`
+}
+
+type FetchInvitesRequest struct {
+	Page  int `form:"page" binding:"required,min=1"`
+	Limit int `form:"limit" binding:"required,min=1,max=100"`
+}
+
+type FetchWaitlistRequest struct {
+	Page   int    `form:"page" binding:"required,min=1"`
+	Limit  int    `form:"limit" binding:"required,min=1,max=100"`
+	Status string `form:"status" binding:"omitempty,oneof=pending invited joined"`
+}
+
+type InviteDTO struct {
+	Id        string     `json:"id"`
+	Code      string     `json:"code"`
+	Note      string     `json:"note"`
+	Email     string     `json:"email"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type WaitlistEntryDTO struct {
+	Id         string     `json:"id"`
+	Email      string     `json:"email"`
+	Note       string     `json:"note"`
+	InvitedAt  *time.Time `json:"invitedAt"`
+	SignedUpAt *time.Time `json:"signedUpAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type FetchInvitesResponse struct {
+	Data  []InviteDTO `json:"data"`
+	Total int         `json:"total"`
+	Page  int         `json:"page"`
+	Limit int         `json:"limit"`
+}
+
+type FetchWaitlistResponse struct {
+	Data  []WaitlistEntryDTO `json:"data"`
+	Total int                `json:"total"`
+	Page  int                `json:"page"`
+	Limit int                `json:"limit"`
+}
+
+// JoinWaitlist godoc
+//
+//	@Summary		Join the waitlist
+//	@Description	Asks for access while signup is invite-only. Responds the same way whether or not the email is already listed.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			joinWaitlistRequest	body		JoinWaitlistRequest		true	"Email and optional note"
+//	@Success		200					{object}	map[string]interface{}	"Added to the waitlist"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		429					{object}	map[string]interface{}	"Too many requests"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/waitlist [post]
+func (e *Endpoint) JoinWaitlist(c *gin.Context) {
+	var request JoinWaitlistRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, retryAfter, err := e.rateLimiter.Allow(c.Request.Context(), "waitlist:ip", c.ClientIP(), waitlistIPLimit, waitlistIPWindow)
+	if err != nil {
+		e.logger.Printf("Failed to check rate limit: %v", err)
+	} else if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+		return
+	}
+
+	entry := model.WaitlistEntry{
+		Email: strings.ToLower(strings.TrimSpace(request.Email)),
+		Note:  strings.TrimSpace(request.Note),
+	}
+	if err := e.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+		e.logger.Printf("Failed to join waitlist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join the waitlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You're on the waitlist, we'll email you an invite when a spot opens up"})
+}
+
+// FetchWaitlist godoc
+//
+//	@Summary		List the waitlist
+//	@Description	Lists waitlist entries, oldest first so they can be invited in order
+//	@Tags			admin
+//	@Produce		json
+//	@Param			page	query		int						true	"Page number"
+//	@Param			limit	query		int						true	"Page size"
+//	@Param			status	query		string					false	"pending, invited or joined"
+//	@Success		200		{object}	FetchWaitlistResponse	"Waitlist entries"
+//	@Failure		400		{object}	map[string]interface{}	"Bad request"
+//	@Failure		403		{object}	map[string]interface{}	"Forbidden"
+//	@Failure		500		{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/waitlist [get]
+func (e *Endpoint) FetchWaitlist(c *gin.Context) {
+	var request FetchWaitlistRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := e.db.Model(&model.WaitlistEntry{})
+	switch request.Status {
+	case "pending":
+		query = query.Where("invited_at IS NULL AND signed_up_at IS NULL")
+	case "invited":
+		query = query.Where("invited_at IS NOT NULL AND signed_up_at IS NULL")
+	case "joined":
+		query = query.Where("signed_up_at IS NOT NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		e.logger.Printf("Failed to count waitlist entries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the waitlist"})
+		return
+	}
+
+	var entries []model.WaitlistEntry
+	if err := query.Order("created_at ASC").Limit(request.Limit).Offset((request.Page - 1) * request.Limit).Find(&entries).Error; err != nil {
+		e.logger.Printf("Failed to fetch waitlist entries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the waitlist"})
+		return
+	}
+
+	entryDTOs := make([]WaitlistEntryDTO, 0, len(entries))
+	for _, entry := range entries {
+		entryDTOs = append(entryDTOs, toWaitlistEntryDTO(entry))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": FetchWaitlistResponse{
+		Data:  entryDTOs,
+		Total: int(total),
+		Page:  request.Page,
+		Limit: request.Limit,
+	}})
+}
+
+// InviteWaitlistEntry godoc
+//
+//	@Summary		Invite someone from the waitlist
+//	@Description	Creates a single-use invite for a waitlist entry and emails it to them
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		string					true	"Waitlist entry id"
+//	@Success		201	{object}	InviteDTO				"Invite sent"
+//	@Failure		403	{object}	map[string]interface{}	"Forbidden"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		409	{object}	map[string]interface{}	"Already signed up"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/waitlist/{id}/invite [post]
+func (e *Endpoint) InviteWaitlistEntry(c *gin.Context) {
+	var entry model.WaitlistEntry
+	if err := e.db.Where("id_external = ?", c.Param("id")).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find waitlist entry"})
+		return
+	}
+	if entry.SignedUpAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This person has already signed up"})
+		return
+	}
+
+	expiresAt := time.Now().Add(waitlistInviteTTL)
+	newInvite := model.Invite{
+		Note:      "waitlist",
+		Email:     entry.Email,
+		MaxUses:   1,
+		CreatorId: creatorId(c),
+		ExpiresAt: &expiresAt,
+	}
+	if err := e.invites.Create(&newInvite); err != nil {
+		e.logger.Printf("Failed to create invite: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	if err := e.sendInvite(c, newInvite); err != nil {
+		e.logger.Printf("Failed to send invite email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invite created but the email could not be sent", "data": toInviteDTO(newInvite)})
+		return
+	}
+
+	if err := e.db.Model(&entry).Updates(map[string]interface{}{"invited_at": time.Now(), "id_invite": newInvite.IdInvite}).Error; err != nil {
+		e.logger.Printf("Failed to update waitlist entry: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Invite sent", "data": toInviteDTO(newInvite)})
+}
+
+// CreateInvite godoc
+//
+//	@Summary		Create an invite code
+//	@Description	Creates an invite code with a usage limit and optional expiry, optionally emailing it
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			createInviteRequest	body		CreateInviteRequest		true	"Invite details"
+//	@Success		201					{object}	InviteDTO				"Created invite"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		403					{object}	map[string]interface{}	"Forbidden"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/invites [post]
+func (e *Endpoint) CreateInvite(c *gin.Context) {
+	var request CreateInviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newInvite := model.Invite{
+		Note:      strings.TrimSpace(request.Note),
+		Email:     strings.ToLower(strings.TrimSpace(request.Email)),
+		MaxUses:   max(request.MaxUses, 1),
+		CreatorId: creatorId(c),
+	}
+	if request.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *request.ExpiresInDays)
+		newInvite.ExpiresAt = &expiresAt
+	}
+
+	if err := e.invites.Create(&newInvite); err != nil {
+		e.logger.Printf("Failed to create invite: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	if newInvite.Email != "" {
+		if err := e.sendInvite(c, newInvite); err != nil {
+			e.logger.Printf("Failed to send invite email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invite created but the email could not be sent", "data": toInviteDTO(newInvite)})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Invite created", "data": toInviteDTO(newInvite)})
+}
+
+// FetchInvites godoc
+//
+//	@Summary		List invite codes
+//	@Description	Lists invite codes with their usage, newest first
+//	@Tags			admin
+//	@Produce		json
+//	@Param			page	query		int						true	"Page number"
+//	@Param			limit	query		int						true	"Page size"
+//	@Success		200		{object}	FetchInvitesResponse	"Invites"
+//	@Failure		400		{object}	map[string]interface{}	"Bad request"
+//	@Failure		403		{object}	map[string]interface{}	"Forbidden"
+//	@Failure		500		{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/invites [get]
+func (e *Endpoint) FetchInvites(c *gin.Context) {
+	var request FetchInvitesRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	if err := e.db.Model(&model.Invite{}).Count(&total).Error; err != nil {
+		e.logger.Printf("Failed to count invites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	var invites []model.Invite
+	if err := e.db.Order("created_at DESC").Limit(request.Limit).Offset((request.Page - 1) * request.Limit).Find(&invites).Error; err != nil {
+		e.logger.Printf("Failed to fetch invites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	inviteDTOs := make([]InviteDTO, 0, len(invites))
+	for _, existing := range invites {
+		inviteDTOs = append(inviteDTOs, toInviteDTO(existing))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": FetchInvitesResponse{
+		Data:  inviteDTOs,
+		Total: int(total),
+		Page:  request.Page,
+		Limit: request.Limit,
+	}})
+}
+
+// RevokeInvite godoc
+//
+//	@Summary		Revoke an invite code
+//	@Description	Stops an invite code from being redeemed. Accounts already created with it are unaffected.
+//	@Tags			admin
+//	@Param			id	path		string					true	"Invite id"
+//	@Success		200	{object}	map[string]interface{}	"Invite revoked"
+//	@Failure		403	{object}	map[string]interface{}	"Forbidden"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/invites/{id} [delete]
+func (e *Endpoint) RevokeInvite(c *gin.Context) {
+	var existing model.Invite
+	if err := e.db.Where("id_external = ?", c.Param("id")).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find invite"})
+		return
+	}
+
+	if existing.RevokedAt == nil {
+		if err := e.db.Model(&existing).Update("revoked_at", time.Now()).Error; err != nil {
+			e.logger.Printf("Failed to revoke invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked"})
+}
+
+func (e *Endpoint) sendInvite(c *gin.Context, sent model.Invite) error {
+	link := fmt.Sprintf("%s/signup?invite=%s", strings.TrimSuffix(e.clientUrl, "/"), url.QueryEscape(sent.Code))
+
+	expiry := ""
+	if sent.ExpiresAt != nil {
+		expiry = fmt.Sprintf(" The invite expires on %s.", sent.ExpiresAt.Format("January 2, 2006"))
+	}
+
+	return e.mailer.Send(c.Request.Context(), mailer.Message{
+		To:      sent.Email,
+		Subject: "You're invited to Iris",
+		Body: fmt.Sprintf("Hi,\n\nYou've been invited to create an Iris account. Sign up using the link below:\n\n%s\n\nOr enter this invite code when signing up: %s\n%s\n",
+			link, invite.FormatCode(sent.Code), expiry),
+	})
+}
+
+func creatorId(c *gin.Context) *uint {
+	if userId := c.GetUint("userId"); userId != 0 {
+		return &userId
+	}
+	return nil
+}
+
+func toInviteDTO(existing model.Invite) InviteDTO {
+	return InviteDTO{
+		Id:        existing.IdExternal.String(),
+		Code:      invite.FormatCode(existing.Code),
+		Note:      existing.Note,
+		Email:     existing.Email,
+		MaxUses:   existing.MaxUses,
+		Uses:      existing.Uses,
+		ExpiresAt: existing.ExpiresAt,
+		RevokedAt: existing.RevokedAt,
+		CreatedAt: existing.CreatedAt,
+	}
+}
+
+func toWaitlistEntryDTO(entry model.WaitlistEntry) WaitlistEntryDTO {
+	return WaitlistEntryDTO{
+		Id:         entry.IdExternal.String(),
+		Email:      entry.Email,
+		Note:       entry.Note,
+		InvitedAt:  entry.InvitedAt,
+		SignedUpAt: entry.SignedUpAt,
+		CreatedAt:  entry.CreatedAt,
+	}
+}
@@ -0,0 +1,487 @@
+package job
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBulkApplyUrls     = 100
+	maxBulkUploadBytes   = 1 << 20
+	bulkApplyConcurrency = 4
+)
+
+// Shown on batch items whose application was cancelled or deleted before its
+// workflow started
+const batchItemCancelledError = "The application was cancelled before it started"
+
+type BulkApplyRequest struct {
+	Urls []string `json:"urls" binding:"required,min=1"`
+}
+
+type BatchItemDTO struct {
+	Url    string                              `json:"url"`
+	Status model.JobApplicationBatchItemStatus `json:"status"`
+	Error  string                              `json:"error,omitempty"`
+	// Set when the URL belongs to one of the user's applications
+	JobApplicationId  string                     `json:"jobApplicationId,omitempty"`
+	ApplicationStatus model.JobApplicationStatus `json:"applicationStatus,omitempty"`
+}
+
+type BatchDTO struct {
+	Id          string                                      `json:"id"`
+	Total       int                                         `json:"total"`
+	Counts      map[model.JobApplicationBatchItemStatus]int `json:"counts"`
+	Items       []BatchItemDTO                              `json:"items"`
+	CreatedAt   time.Time                                   `json:"createdAt"`
+	CompletedAt *time.Time                                  `json:"completedAt"`
+}
+
+// BulkApply godoc
+//
+//	@Summary		Apply to many jobs at once
+//	@Description	Accepts up to 100 URLs as a JSON body, a text/csv body or a multipart CSV upload in the file field. CSV uploads use the url column when there is a header row and the first column otherwise. Invalid and repeated URLs are reported per item; the rest are applied to in the background. Progress can be polled at /jobs/batches/{id} or followed over /realtime/events as BATCH_ITEM_UPDATED and BATCH_COMPLETED events.
+//	@Tags			jobs
+//	@Accept			json,mpfd,text/csv
+//	@Produce		json
+//	@Param			bulkApplyRequest	body		BulkApplyRequest		false	"URLs to apply to"
+//	@Param			file				formData	file					false	"CSV file of URLs"
+//	@Success		202					{object}	BatchDTO				"Batch accepted"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		401					{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/jobs/apply/bulk [post]
+func (e *Endpoint) BulkApply(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkUploadBytes)
+	rawUrls, err := readBulkUrls(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rawUrls) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No URLs were submitted"})
+		return
+	}
+	if len(rawUrls) > maxBulkApplyUrls {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A batch can hold at most %d URLs", maxBulkApplyUrls)})
+		return
+	}
+
+	resumeId, err := e.activeResumeId(userId)
+	if err != nil {
+		e.logger.Printf("Failed to create job application batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job application batch"})
+		return
+	}
+
+	batch := model.JobApplicationBatch{UserId: userId}
+	seen := make(map[string]bool, len(rawUrls))
+	for i, rawUrl := range rawUrls {
+		item := model.JobApplicationBatchItem{UserId: userId, Position: i, Url: strings.TrimSpace(rawUrl), Status: model.JobApplicationBatchItemQueued}
+		normalized, err := normalizeJobUrl(rawUrl)
+		switch {
+		case err != nil:
+			item.Status = model.JobApplicationBatchItemInvalid
+			item.Error = err.Error()
+		case seen[normalized]:
+			item.Url = normalized
+			item.Status = model.JobApplicationBatchItemDuplicate
+			item.Error = "Listed earlier in this batch"
+		default:
+			item.Url = normalized
+			seen[normalized] = true
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	if err := e.db.Create(&batch).Error; err != nil {
+		e.logger.Printf("Failed to create job application batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job application batch"})
+		return
+	}
+
+	// Rows are created up front so duplicates are reported in the response;
+	// only starting the workflows is left to the background
+	var queued []model.JobApplicationBatchItem
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		if item.Status != model.JobApplicationBatchItemQueued {
+			continue
+		}
+		if err := e.createBatchApplication(item, resumeId); err != nil {
+			e.logger.Printf("Failed to create job application for batch %s: %v", batch.IdExternal, err)
+			item.Status = model.JobApplicationBatchItemFailed
+			item.Error = "The application could not be created"
+		}
+		if err := e.db.Model(item).Updates(map[string]interface{}{
+			"status":             item.Status,
+			"error":              item.Error,
+			"id_job_application": item.JobApplicationId,
+		}).Error; err != nil {
+			e.logger.Printf("Failed to update batch item: %v", err)
+		}
+		if item.Status == model.JobApplicationBatchItemQueued {
+			queued = append(queued, *item)
+		}
+	}
+
+	// The response is built before the items are handed to the background,
+	// which works on its own copies
+	batchDTO := toBatchDTO(batch)
+	if len(queued) == 0 {
+		e.completeBatch(context.Background(), &batch)
+		batchDTO.CompletedAt = batch.CompletedAt
+	} else {
+		go e.runBatch(context.Background(), batch, queued)
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionJobBulkApply,
+		Outcome:    model.AuditOutcomeSuccess,
+		TargetType: "job_application_batch",
+		TargetId:   batch.IdExternal.String(),
+		Metadata:   map[string]interface{}{"total": batchDTO.Total, "counts": batchDTO.Counts},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job applications queued", "data": batchDTO})
+}
+
+// FetchBatch godoc
+//
+//	@Summary		Get a bulk apply batch
+//	@Description	Returns the progress of one of the current user's bulk apply batches with the current status of each application
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string					true	"Batch id"
+//	@Success		200	{object}	BatchDTO				"Batch"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/jobs/batches/{id} [get]
+func (e *Endpoint) FetchBatch(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var batch model.JobApplicationBatch
+	err := e.db.
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Preload("Items.JobApplication", "deleted_at IS NULL").
+		Where("id_external = ? AND id_user = ?", c.Param("id"), userId).
+		First(&batch).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+			return
+		}
+		e.logger.Printf("Failed to fetch job application batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch batch"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": toBatchDTO(batch)})
+}
+
+// createBatchApplication creates the application for a queued item, or marks
+// the item a duplicate when the URL has been applied to already
+func (e *Endpoint) createBatchApplication(item *model.JobApplicationBatchItem, resumeId *uint) error {
+	var existing model.JobApplication
+	err := e.db.Where("url = ?", item.Url).First(&existing).Error
+	if err == nil {
+		item.Status = model.JobApplicationBatchItemDuplicate
+		item.Error = "This job has already been applied to"
+		// Applications of other users are not revealed
+		if existing.UserId == item.UserId && existing.DeletedAt == nil {
+			item.JobApplicationId = &existing.IdJobApplication
+			item.JobApplication = &existing
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	jobApplication := model.JobApplication{
+		Url:            item.Url,
+		JobTitle:       "Pending-Job-Title",
+		CompanyName:    "Pending-Company-Name",
+		JobDescription: "Pending-Job-Description",
+		Status:         model.JobApplicationStatusPending,
+		UserId:         item.UserId,
+		ResumeId:       resumeId,
+	}
+	if err := e.db.Create(&jobApplication).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			item.Status = model.JobApplicationBatchItemDuplicate
+			item.Error = "This job has already been applied to"
+			return nil
+		}
+		return err
+	}
+	item.JobApplicationId = &jobApplication.IdJobApplication
+	item.JobApplication = &jobApplication
+	return nil
+}
+
+// runBatch starts the workflows of the queued items, a few at a time so a
+// large batch does not flood Temporal, and reports each one as it goes. A
+// batch interrupted by ctx is left incomplete for ResumeBatches.
+func (e *Endpoint) runBatch(ctx context.Context, batch model.JobApplicationBatch, queued []model.JobApplicationBatchItem) {
+	slots := make(chan struct{}, bulkApplyConcurrency)
+	var wg sync.WaitGroup
+	for i := range queued {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(item *model.JobApplicationBatchItem) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			e.startBatchItem(batch, item)
+			if err := e.db.Model(item).Updates(map[string]interface{}{"status": item.Status, "error": item.Error}).Error; err != nil {
+				e.logger.Printf("Failed to update batch item: %v", err)
+			}
+
+			e.publishBatchEvent(ctx, batch, redispubsub.ActionBatchItemUpdated, gin.H{"batchId": batch.IdExternal.String(), "item": toBatchItemDTO(*item)})
+		}(&queued[i])
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	e.completeBatch(ctx, &batch)
+}
+
+// startBatchItem starts the application of a queued item and records the
+// outcome on the item. The application is read again first, as it may have
+// been cancelled or deleted since the batch was accepted, or started before
+// the server restarted.
+func (e *Endpoint) startBatchItem(batch model.JobApplicationBatch, item *model.JobApplicationBatchItem) {
+	item.JobApplication = nil
+	if item.JobApplicationId == nil {
+		item.Status = model.JobApplicationBatchItemFailed
+		item.Error = "The application could not be created"
+		return
+	}
+
+	var jobApplication model.JobApplication
+	if err := e.db.Where("id_job_application = ?", *item.JobApplicationId).First(&jobApplication).Error; err != nil {
+		e.logger.Printf("Failed to load job application for batch %s: %v", batch.IdExternal, err)
+		item.Status = model.JobApplicationBatchItemFailed
+		item.Error = workflowStartFailureReason
+		return
+	}
+	if jobApplication.DeletedAt == nil {
+		item.JobApplication = &jobApplication
+	}
+
+	switch {
+	case jobApplication.WorkflowId != "":
+		item.Status = model.JobApplicationBatchItemStarted
+		return
+	case jobApplication.Status != model.JobApplicationStatusPending || jobApplication.CancelRequestedAt != nil || jobApplication.DeletedAt != nil:
+		item.Status = model.JobApplicationBatchItemCancelled
+		item.Error = batchItemCancelledError
+		return
+	}
+
+	if err := e.startAttempt(&jobApplication, 1); errors.Is(err, errAttemptAbandoned) {
+		item.Status = model.JobApplicationBatchItemCancelled
+		item.Error = batchItemCancelledError
+	} else if err != nil {
+		e.logger.Printf("Failed to start job application for batch %s: %v", batch.IdExternal, err)
+		item.Status = model.JobApplicationBatchItemFailed
+		item.Error = workflowStartFailureReason
+	} else {
+		item.Status = model.JobApplicationBatchItemStarted
+	}
+}
+
+// ResumeBatches starts the items that were still queued when the server last
+// stopped, one batch at a time, and returns once they have all been started
+// or ctx is done
+func (e *Endpoint) ResumeBatches(ctx context.Context) {
+	var batches []model.JobApplicationBatch
+	err := e.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB {
+			return db.Where("status = ?", model.JobApplicationBatchItemQueued).Order("position ASC")
+		}).
+		Where("completed_at IS NULL").
+		Order("created_at ASC").
+		Find(&batches).Error
+	if err != nil {
+		e.logger.Printf("Failed to load unfinished job application batches: %v", err)
+		return
+	}
+
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			return
+		}
+		e.logger.Printf("Resuming job application batch %s with %d queued items", batch.IdExternal, len(batch.Items))
+		queued := batch.Items
+		batch.Items = nil
+		e.runBatch(ctx, batch, queued)
+	}
+}
+
+// completeBatch marks the batch complete and publishes its final counts,
+// which are read back from the stored items
+func (e *Endpoint) completeBatch(ctx context.Context, batch *model.JobApplicationBatch) {
+	now := time.Now()
+	batch.CompletedAt = &now
+	if err := e.db.Model(batch).Update("completed_at", now).Error; err != nil {
+		e.logger.Printf("Failed to complete job application batch %s: %v", batch.IdExternal, err)
+	}
+
+	var items []model.JobApplicationBatchItem
+	if err := e.db.Where("id_job_application_batch = ?", batch.IdJobApplicationBatch).Order("position ASC").Find(&items).Error; err != nil {
+		e.logger.Printf("Failed to load items of job application batch %s: %v", batch.IdExternal, err)
+	} else {
+		batch.Items = items
+	}
+
+	summary := toBatchDTO(*batch)
+	summary.Items = nil
+	e.publishBatchEvent(ctx, *batch, redispubsub.ActionBatchCompleted, summary)
+}
+
+func (e *Endpoint) publishBatchEvent(ctx context.Context, batch model.JobApplicationBatch, action redispubsub.ActionType, data interface{}) {
+	if err := e.redisPubSub.PublishToUser(ctx, fmt.Sprintf("%d", batch.UserId), action, data); err != nil {
+		e.logger.Printf("Failed to publish %s for batch %s: %v", action, batch.IdExternal, err)
+	}
+}
+
+// readBulkUrls reads the submitted URLs from a JSON body, a CSV body or a
+// multipart CSV upload
+func readBulkUrls(c *gin.Context) ([]string, error) {
+	switch c.ContentType() {
+	case "multipart/form-data":
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, errors.New("upload a CSV file in the file field")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, errors.New("the uploaded file could not be read")
+		}
+		defer file.Close()
+		return readCSVUrls(file)
+	case "text/csv":
+		return readCSVUrls(c.Request.Body)
+	default:
+		var request BulkApplyRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return nil, err
+		}
+		return request.Urls, nil
+	}
+}
+
+// readCSVUrls takes URLs from the url column when the first row is a header
+// naming one, and from the first column otherwise. Empty cells are skipped.
+func readCSVUrls(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("the CSV file could not be parsed: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	column := 0
+	for i, header := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(header), "url") {
+			column = i
+			records = records[1:]
+			break
+		}
+	}
+
+	var urls []string
+	for _, record := range records {
+		if column < len(record) && strings.TrimSpace(record[column]) != "" {
+			urls = append(urls, record[column])
+		}
+	}
+	return urls, nil
+}
+
+// normalizeJobUrl checks that rawUrl is an absolute http(s) URL and returns it
+// with the scheme and host lowercased and any fragment removed, so the same
+// posting is recognised however it was pasted
+func normalizeJobUrl(rawUrl string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", errors.New("Not a valid URL")
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", errors.New("Only http and https URLs are supported")
+	}
+	if parsed.Host == "" {
+		return "", errors.New("URL has no host")
+	}
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	return parsed.String(), nil
+}
+
+func toBatchItemDTO(item model.JobApplicationBatchItem) BatchItemDTO {
+	itemDTO := BatchItemDTO{
+		Url:    item.Url,
+		Status: item.Status,
+		Error:  item.Error,
+	}
+	if item.JobApplication != nil {
+		itemDTO.JobApplicationId = item.JobApplication.IdExternal.String()
+		itemDTO.ApplicationStatus = item.JobApplication.Status
+	}
+	return itemDTO
+}
+
+func toBatchDTO(batch model.JobApplicationBatch) BatchDTO {
+	batchDTO := BatchDTO{
+		Id:          batch.IdExternal.String(),
+		Total:       len(batch.Items),
+		Counts:      make(map[model.JobApplicationBatchItemStatus]int),
+		Items:       make([]BatchItemDTO, 0, len(batch.Items)),
+		CreatedAt:   batch.CreatedAt,
+		CompletedAt: batch.CompletedAt,
+	}
+	for _, item := range batch.Items {
+		batchDTO.Counts[item.Status]++
+		batchDTO.Items = append(batchDTO.Items, toBatchItemDTO(item))
+	}
+	return batchDTO
+}
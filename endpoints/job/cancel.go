@@ -0,0 +1,201 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/SomtoJF/iris-api/temporal"
+	"github.com/gin-gonic/gin"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"gorm.io/gorm"
+)
+
+// How long a cancel request waits for the workflow to acknowledge before
+// answering and finishing in the background
+const cancelAcknowledgementTimeout = 10 * time.Second
+
+// CancelJobApplication godoc
+//
+//	@Summary		Cancel a job application
+//	@Description	Stops the workflow of an application that is still processing. Responds 200 once the workflow has acknowledged, or 202 when it is still winding down; an APPLICATION_CANCELLED realtime event follows either way.
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string					true	"Job application id"
+//	@Success		200	{object}	map[string]interface{}	"Job application cancelled"
+//	@Success		202	{object}	map[string]interface{}	"Cancellation requested"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		409	{object}	map[string]interface{}	"Already submitted or no longer processing"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/jobs/{id}/cancel [post]
+func (e *Endpoint) CancelJobApplication(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var jobApplication model.JobApplication
+	if err := e.db.Where("id_external = ? AND id_user = ? AND deleted_at IS NULL", c.Param("id"), userId).First(&jobApplication).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job application not found"})
+			return
+		}
+		e.logger.Printf("Failed to fetch job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job application"})
+		return
+	}
+
+	switch jobApplication.Status {
+	case model.JobApplicationStatusCancelled:
+		c.JSON(http.StatusOK, gin.H{"message": "Job application already cancelled"})
+		return
+	case model.JobApplicationStatusApplied, model.JobApplicationStatusFailed:
+		respondNotCancellable(c, jobApplication.Status)
+		return
+	}
+
+	now := time.Now()
+	if err := e.db.Model(&jobApplication).Update("cancel_requested_at", now).Error; err != nil {
+		e.logger.Printf("Failed to record cancellation request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job application"})
+		return
+	}
+
+	// The workflow is read back only now, as no run starts once the request is
+	// recorded and one that was starting meanwhile has reserved its id
+	if err := e.db.Select("workflow_id", "run_id").Where("id_job_application = ?", jobApplication.IdJobApplication).First(&jobApplication).Error; err != nil {
+		e.logger.Printf("Failed to reload job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job application"})
+		return
+	}
+
+	// An application whose workflow never started has nothing to stop
+	if jobApplication.WorkflowId == "" {
+		e.finishCancellation(c.Request.Context(), jobApplication, enumspb.WORKFLOW_EXECUTION_STATUS_CANCELED)
+		e.recordCancel(c, jobApplication, model.AuditOutcomeSuccess)
+		c.JSON(http.StatusOK, gin.H{"message": "Job application cancelled"})
+		return
+	}
+
+	// A workflow that already closed cannot be cancelled, but how it closed
+	// still decides the answer below
+	err := e.temporalClient.CancelWorkflow(c.Request.Context(), jobApplication.WorkflowId, jobApplication.RunId)
+	var notFound *serviceerror.NotFound
+	if err != nil && !errors.As(err, &notFound) {
+		e.logger.Printf("Failed to cancel workflow %s: %v", jobApplication.WorkflowId, err)
+		e.recordCancel(c, jobApplication, model.AuditOutcomeFailure)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job application"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), cancelAcknowledgementTimeout)
+	defer cancel()
+	workflowStatus, err := temporal.WaitForClose(ctx, e.temporalClient, jobApplication.WorkflowId, jobApplication.RunId)
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			e.logger.Printf("Failed to wait for workflow %s to close: %v", jobApplication.WorkflowId, err)
+		}
+		go e.awaitCancellation(jobApplication)
+		e.recordCancel(c, jobApplication, model.AuditOutcomeSuccess)
+		c.JSON(http.StatusAccepted, gin.H{"message": "Cancellation requested, the application will stop shortly"})
+		return
+	}
+
+	status := e.finishCancellation(c.Request.Context(), jobApplication, workflowStatus)
+	if status != model.JobApplicationStatusCancelled {
+		// The worker may not have recorded how the run ended yet
+		if workflowStatus == enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED {
+			status = model.JobApplicationStatusApplied
+		} else if status == model.JobApplicationStatusPending {
+			status = model.JobApplicationStatusFailed
+		}
+		e.recordCancel(c, jobApplication, model.AuditOutcomeFailure)
+		respondNotCancellable(c, status)
+		return
+	}
+
+	e.recordCancel(c, jobApplication, model.AuditOutcomeSuccess)
+	c.JSON(http.StatusOK, gin.H{"message": "Job application cancelled"})
+}
+
+// respondNotCancellable explains why an application in status cannot be cancelled
+func respondNotCancellable(c *gin.Context, status model.JobApplicationStatus) {
+	if status == model.JobApplicationStatusApplied {
+		c.JSON(http.StatusConflict, gin.H{"error": "This application has already been submitted", "code": "ALREADY_APPLIED"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "This application is no longer processing", "code": "NOT_CANCELLABLE", "status": status})
+}
+
+// respondAbandoned answers a request whose workflow was not started because
+// the application was cancelled or deleted first
+func respondAbandoned(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": "This application was cancelled before it started", "code": "CANCELLED"})
+}
+
+// awaitCancellation finishes a cancellation the workflow did not acknowledge
+// while the request was open
+func (e *Endpoint) awaitCancellation(jobApplication model.JobApplication) {
+	ctx, cancel := context.WithTimeout(context.Background(), workflowExecutionTimeout)
+	defer cancel()
+
+	workflowStatus, err := temporal.WaitForClose(ctx, e.temporalClient, jobApplication.WorkflowId, jobApplication.RunId)
+	if err != nil {
+		e.logger.Printf("Gave up waiting for workflow %s to cancel: %v", jobApplication.WorkflowId, err)
+		return
+	}
+	e.finishCancellation(ctx, jobApplication, workflowStatus)
+}
+
+// finishCancellation moves the application to cancelled when its workflow
+// was cancelled and notifies the user, then returns the application's status.
+// A workflow that completed before the cancellation reached it keeps the
+// status the worker gave it.
+func (e *Endpoint) finishCancellation(ctx context.Context, jobApplication model.JobApplication, workflowStatus enumspb.WorkflowExecutionStatus) model.JobApplicationStatus {
+	if workflowStatus == enumspb.WORKFLOW_EXECUTION_STATUS_CANCELED || workflowStatus == enumspb.WORKFLOW_EXECUTION_STATUS_TERMINATED {
+		// The worker may already have marked the stopped run as failed
+		result := e.db.Model(&model.JobApplication{}).
+			Where("id_job_application = ? AND status IN ?", jobApplication.IdJobApplication, []model.JobApplicationStatus{model.JobApplicationStatusPending, model.JobApplicationStatusFailed}).
+			Updates(map[string]interface{}{"status": model.JobApplicationStatusCancelled, "failure_reason": nil})
+		if result.Error != nil {
+			e.logger.Printf("Failed to mark job application %s as cancelled: %v", jobApplication.IdExternal, result.Error)
+		} else if result.RowsAffected > 0 {
+			e.publishCancelled(ctx, jobApplication)
+		}
+	}
+
+	var current model.JobApplication
+	if err := e.db.Select("status").Where("id_job_application = ?", jobApplication.IdJobApplication).First(&current).Error; err != nil {
+		e.logger.Printf("Failed to reload job application %s: %v", jobApplication.IdExternal, err)
+		return jobApplication.Status
+	}
+	return current.Status
+}
+
+func (e *Endpoint) publishCancelled(ctx context.Context, jobApplication model.JobApplication) {
+	data := map[string]interface{}{
+		"id":  jobApplication.IdExternal.String(),
+		"url": jobApplication.Url,
+	}
+	if err := e.redisPubSub.PublishToUser(ctx, fmt.Sprintf("%d", jobApplication.UserId), redispubsub.ActionApplicationCancelled, data); err != nil {
+		e.logger.Printf("Failed to publish cancellation of job application %s: %v", jobApplication.IdExternal, err)
+	}
+}
+
+func (e *Endpoint) recordCancel(c *gin.Context, jobApplication model.JobApplication, outcome model.AuditOutcome) {
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionJobCancel,
+		Outcome:    outcome,
+		TargetType: "job_application",
+		TargetId:   jobApplication.IdExternal.String(),
+		Metadata:   map[string]interface{}{"url": jobApplication.Url},
+	})
+}
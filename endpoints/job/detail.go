@@ -0,0 +1,207 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/temporal"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Workflow history is a nice-to-have on the detail page, so a slow Temporal
+// server must not hold the response for long
+const workflowHistoryTimeout = 5 * time.Second
+
+const timelineStatusChanged = "status_changed"
+
+type TimelineEntry struct {
+	// status_changed, or one of the workflow_* and step_* workflow events
+	Type       string                     `json:"type"`
+	At         time.Time                  `json:"at"`
+	FromStatus model.JobApplicationStatus `json:"fromStatus,omitempty"`
+	ToStatus   model.JobApplicationStatus `json:"toStatus,omitempty"`
+	Step       string                     `json:"step,omitempty"`
+	// Retry attempt of the step within its workflow run
+	Attempt int32 `json:"attempt,omitempty"`
+	// Number of the application attempt whose workflow run the event is from
+	ApplicationAttempt int    `json:"applicationAttempt,omitempty"`
+	Message            string `json:"message,omitempty"`
+}
+
+type AttemptDTO struct {
+	Number        int                        `json:"number"`
+	WorkflowId    string                     `json:"workflowId"`
+	RunId         string                     `json:"runId"`
+	Status        model.JobApplicationStatus `json:"status"`
+	FailureReason string                     `json:"failureReason,omitempty"`
+	StartedAt     time.Time                  `json:"startedAt"`
+	FinishedAt    *time.Time                 `json:"finishedAt"`
+}
+
+// ResumeSummary describes the resume that was active when the application was
+// submitted. Its id is passed to the worker, but which file the worker ended
+// up sending is not reported back, so it is not claimed as the one used.
+type ResumeSummary struct {
+	Id       string `json:"id"`
+	FileName string `json:"fileName"`
+	Url      string `json:"url"`
+	// False once the resume has been deleted; the summary is kept for the record
+	Available bool `json:"available"`
+}
+
+type JobApplicationDetail struct {
+	Id                  string                     `json:"id"`
+	Url                 string                     `json:"url"`
+	Status              model.JobApplicationStatus `json:"status"`
+	JobTitle            string                     `json:"jobTitle"`
+	CompanyName         string                     `json:"companyName"`
+	JobDescription      string                     `json:"jobDescription"`
+	FailureReason       string                     `json:"failureReason,omitempty"`
+	ResumeActiveAtApply *ResumeSummary             `json:"resumeActiveAtApply"`
+	Timeline            []TimelineEntry            `json:"timeline"`
+	Attempts            []AttemptDTO               `json:"attempts"`
+	// Set once the user has asked for the application to be cancelled
+	CancelRequestedAt *time.Time `json:"cancelRequestedAt,omitempty"`
+	// False when the steps of some workflow run could not be loaded and are
+	// missing from the timeline
+	WorkflowHistoryAvailable bool      `json:"workflowHistoryAvailable"`
+	CreatedAt                time.Time `json:"createdAt"`
+	UpdatedAt                time.Time `json:"updatedAt"`
+}
+
+// FetchJobApplication godoc
+//
+//	@Summary		Get a job application
+//	@Description	Returns one of the current user's job applications with the resume that was active when it was submitted, why it failed if it did, and a chronological timeline of status changes and workflow steps
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string					true	"Job application id"
+//	@Success		200	{object}	JobApplicationDetail	"Job application"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/jobs/{id} [get]
+func (e *Endpoint) FetchJobApplication(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var jobApplication model.JobApplication
+	if err := e.db.Preload("Resume").Where("id_external = ? AND id_user = ? AND deleted_at IS NULL", c.Param("id"), userId).First(&jobApplication).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job application not found"})
+			return
+		}
+		e.logger.Printf("Failed to fetch job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job application"})
+		return
+	}
+
+	var statusChanges []model.JobApplicationStatusChange
+	if err := e.db.Where("id_job_application = ?", jobApplication.IdJobApplication).Order("created_at ASC, id_job_application_status_change ASC").Find(&statusChanges).Error; err != nil {
+		e.logger.Printf("Failed to fetch job application status history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job application"})
+		return
+	}
+
+	var attempts []model.JobApplicationAttempt
+	if err := e.db.Where("id_job_application = ?", jobApplication.IdJobApplication).Order("number ASC").Find(&attempts).Error; err != nil {
+		e.logger.Printf("Failed to fetch job application attempts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job application"})
+		return
+	}
+
+	timeline := make([]TimelineEntry, 0, len(statusChanges))
+	for _, change := range statusChanges {
+		timeline = append(timeline, TimelineEntry{
+			Type:       timelineStatusChanged,
+			At:         change.CreatedAt,
+			FromStatus: change.FromStatus,
+			ToStatus:   change.ToStatus,
+		})
+	}
+
+	detail := JobApplicationDetail{
+		Id:             jobApplication.IdExternal.String(),
+		Url:            jobApplication.Url,
+		Status:         jobApplication.Status,
+		JobTitle:       jobApplication.JobTitle,
+		CompanyName:    jobApplication.CompanyName,
+		JobDescription: jobApplication.JobDescription,
+		FailureReason:  jobApplication.FailureReason,
+		CreatedAt:      jobApplication.CreatedAt,
+		UpdatedAt:      jobApplication.UpdatedAt,
+
+		CancelRequestedAt: jobApplication.CancelRequestedAt,
+	}
+	if jobApplication.Resume != nil {
+		detail.ResumeActiveAtApply = &ResumeSummary{
+			Id:        jobApplication.Resume.IdExternal.String(),
+			FileName:  jobApplication.Resume.FileName,
+			Url:       jobApplication.Resume.Url,
+			Available: jobApplication.Resume.DeletedAt == nil,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), workflowHistoryTimeout)
+	defer cancel()
+
+	detail.WorkflowHistoryAvailable = true
+	detail.Attempts = make([]AttemptDTO, 0, len(attempts))
+	for _, attempt := range attempts {
+		attemptDTO := AttemptDTO{
+			Number:        attempt.Number,
+			WorkflowId:    attempt.WorkflowId,
+			RunId:         attempt.RunId,
+			Status:        attempt.Outcome,
+			FailureReason: attempt.FailureReason,
+			StartedAt:     attempt.StartedAt,
+			FinishedAt:    attempt.FinishedAt,
+		}
+		latest := attempt.FinishedAt == nil
+
+		if attempt.WorkflowId != "" {
+			workflowEvents, err := temporal.WorkflowHistory(ctx, e.temporalClient, attempt.WorkflowId, attempt.RunId)
+			if err != nil {
+				e.logger.Printf("Failed to fetch workflow history for %s: %v", attempt.WorkflowId, err)
+				detail.WorkflowHistoryAvailable = false
+			} else {
+				for _, event := range workflowEvents {
+					timeline = append(timeline, TimelineEntry{
+						Type:               string(event.Type),
+						At:                 event.At,
+						Step:               event.Step,
+						Attempt:            event.Attempt,
+						ApplicationAttempt: attempt.Number,
+						Message:            event.Message,
+					})
+				}
+				if latest && detail.FailureReason == "" && jobApplication.Status == model.JobApplicationStatusFailed {
+					detail.FailureReason = temporal.FailureReason(workflowEvents)
+					e.logFailureDetails(attempt.WorkflowId, workflowEvents)
+				}
+			}
+		}
+
+		// The application holds the state of the attempt still in effect
+		if latest {
+			attemptDTO.Status = jobApplication.Status
+			attemptDTO.FailureReason = detail.FailureReason
+		}
+		detail.Attempts = append(detail.Attempts, attemptDTO)
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].At.Before(timeline[j].At)
+	})
+	detail.Timeline = timeline
+
+	c.JSON(http.StatusOK, gin.H{"data": detail})
+}
@@ -1,29 +1,36 @@
 package job
 
 import (
-	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
 	"github.com/SomtoJF/iris-api/temporal"
 	"github.com/gin-gonic/gin"
 	"go.temporal.io/sdk/client"
 	"gorm.io/gorm"
 )
 
+// workflowExecutionTimeout bounds a whole job application workflow run
+const workflowExecutionTimeout = 40 * time.Minute
+
 type Endpoint struct {
 	db             *gorm.DB
 	temporalClient client.Client
+	redisPubSub    *redispubsub.RedisPubSub
+	audit          *audit.Recorder
 	logger         *log.Logger
 	taskQueueName  temporal.TaskQueueName
 }
 
-func NewEndpoint(db *gorm.DB, temporalClient client.Client, logger *log.Logger, taskQueueName temporal.TaskQueueName) *Endpoint {
-	return &Endpoint{db: db, temporalClient: temporalClient, logger: logger, taskQueueName: taskQueueName}
+func NewEndpoint(db *gorm.DB, temporalClient client.Client, redisPubSub *redispubsub.RedisPubSub, auditRecorder *audit.Recorder, logger *log.Logger, taskQueueName temporal.TaskQueueName) *Endpoint {
+	return &Endpoint{db: db, temporalClient: temporalClient, redisPubSub: redisPubSub, audit: auditRecorder, logger: logger, taskQueueName: taskQueueName}
 }
 
 type ApplyForJobRequest struct {
@@ -34,6 +41,9 @@ type JobApplicationWorkflowInput struct {
 	Url              string `json:"url"`
 	IdUser           uint   `json:"id_user"`
 	IdJobApplication uint   `json:"id_job_application"`
+	// The resume that was active when the user applied. The worker is not
+	// required to use it, so it is never reported as the resume that was sent.
+	IdResume uint `json:"id_resume,omitempty"`
 }
 
 func (e *Endpoint) ApplyForJob(c *gin.Context) {
@@ -49,16 +59,36 @@ func (e *Endpoint) ApplyForJob(c *gin.Context) {
 		return
 	}
 
+	// URLs are unique across all applications, so they are stored the same
+	// way bulk apply stores them
+	jobUrl, err := normalizeJobUrl(request.Url)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	jobApplication := model.JobApplication{
-		Url:            request.Url,
+		Url:            jobUrl,
 		JobTitle:       "Pending-Job-Title",
 		CompanyName:    "Pending-Company-Name",
 		JobDescription: "Pending-Job-Description",
 		Status:         model.JobApplicationStatusPending,
 		UserId:         userId,
 	}
+
+	// The application is made with the resume that is active now, even if the
+	// user switches resumes while it is in progress
+	resumeId, err := e.activeResumeId(userId)
+	if err != nil {
+		e.logger.Printf("Failed to create job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job application"})
+		return
+	}
+	jobApplication.ResumeId = resumeId
+
 	if err := e.db.Create(&jobApplication).Error; err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			e.recordApply(c, "", jobUrl, model.AuditOutcomeFailure, "duplicate")
 			c.JSON(http.StatusConflict, gin.H{"error": "Job application already exists"})
 			return
 		}
@@ -67,39 +97,64 @@ func (e *Endpoint) ApplyForJob(c *gin.Context) {
 		return
 	}
 
-	workflowOptions := client.StartWorkflowOptions{
-		ID:                       fmt.Sprintf("job-application-%s-%d", request.Url, time.Now().Unix()),
-		TaskQueue:                string(e.taskQueueName),
-		WorkflowExecutionTimeout: 40 * time.Minute,
-		WorkflowTaskTimeout:      1 * time.Minute,
-	}
-
-	workflowInput := JobApplicationWorkflowInput{
-		Url:              request.Url,
-		IdJobApplication: jobApplication.IdJobApplication,
-		IdUser:           userId,
-	}
-	_, err := e.temporalClient.ExecuteWorkflow(context.Background(), workflowOptions, "JobApplicationWorkflow", workflowInput)
-	if err != nil {
+	if err := e.startAttempt(&jobApplication, 1); err != nil {
+		if errors.Is(err, errAttemptAbandoned) {
+			e.recordApply(c, jobApplication.IdExternal.String(), jobUrl, model.AuditOutcomeFailure, "cancelled")
+			respondAbandoned(c)
+			return
+		}
 		e.logger.Printf("Failed to start job application process: %v", err)
+		e.recordApply(c, jobApplication.IdExternal.String(), jobUrl, model.AuditOutcomeFailure, "workflow_start_failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start job application process"})
 		return
 	}
 
+	e.recordApply(c, jobApplication.IdExternal.String(), jobUrl, model.AuditOutcomeSuccess, "")
+
 	c.JSON(http.StatusAccepted, gin.H{"message": "Job application initiated"})
 }
 
+func (e *Endpoint) recordApply(c *gin.Context, jobApplicationId string, url string, outcome model.AuditOutcome, reason string) {
+	metadata := map[string]interface{}{"url": url}
+	if reason != "" {
+		metadata["reason"] = reason
+	}
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionJobApply,
+		Outcome:    outcome,
+		TargetType: "job_application",
+		TargetId:   jobApplicationId,
+		Metadata:   metadata,
+	})
+}
+
 type FetchAllJobApplicationsRequest struct {
-	Page  int `form:"page" binding:"required"`
-	Limit int `form:"limit" binding:"required"`
+	Page  int `form:"page" binding:"required,min=1"`
+	Limit int `form:"limit" binding:"required,min=1,max=100"`
+	// Free text matched against job title, company and description
+	Q       string   `form:"q" binding:"max=200"`
+	Status  []string `form:"status" binding:"omitempty,dive,oneof=processing applied failed cancelled"`
+	Company string   `form:"company" binding:"max=255"`
+	Ats     []string `form:"ats" binding:"omitempty,dive,oneof=greenhouse lever workday ashby smartrecruiters workable bamboohr icims other"`
+	// RFC 3339 timestamps bounding CreatedAt and UpdatedAt
+	CreatedFrom *time.Time `form:"createdFrom" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedTo   *time.Time `form:"createdTo" time_format:"2006-01-02T15:04:05Z07:00"`
+	UpdatedFrom *time.Time `form:"updatedFrom" time_format:"2006-01-02T15:04:05Z07:00"`
+	UpdatedTo   *time.Time `form:"updatedTo" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Defaults to relevance when searching and createdAt otherwise
+	Sort  string `form:"sort" binding:"omitempty,oneof=createdAt updatedAt jobTitle companyName status relevance"`
+	Order string `form:"order" binding:"omitempty,oneof=asc desc"`
 }
 
 type JobApplication struct {
-	Id        string                     `json:"id"`
-	Url       string                     `json:"url"`
-	Status    model.JobApplicationStatus `json:"status"`
-	CreatedAt time.Time                  `json:"createdAt"`
-	UpdatedAt time.Time                  `json:"updatedAt"`
+	Id          string                     `json:"id"`
+	Url         string                     `json:"url"`
+	Status      model.JobApplicationStatus `json:"status"`
+	JobTitle    string                     `json:"jobTitle"`
+	CompanyName string                     `json:"companyName"`
+	AtsType     model.AtsType              `json:"atsType"`
+	CreatedAt   time.Time                  `json:"createdAt"`
+	UpdatedAt   time.Time                  `json:"updatedAt"`
 }
 
 type FetchAllJobApplicationsResponse struct {
@@ -109,6 +164,39 @@ type FetchAllJobApplicationsResponse struct {
 	Limit int              `json:"limit"`
 }
 
+// jobApplicationSortColumns maps the sort parameter to columns, qualified
+// because searches join the full-text index, which shares column names
+var jobApplicationSortColumns = map[string]string{
+	"createdAt":   "job_application.created_at",
+	"updatedAt":   "job_application.updated_at",
+	"jobTitle":    "job_application.job_title",
+	"companyName": "job_application.company_name",
+	"status":      "job_application.status",
+}
+
+// FetchAllJobApplications godoc
+//
+//	@Summary		List job applications
+//	@Description	Lists the current user's job applications. Filters combine with AND; status and ats can be repeated to match any of several values. q runs a full-text search over title, company and description matching every word as a prefix, and must contain at least one letter or digit.
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			page		query		int								true	"Page number"
+//	@Param			limit		query		int								true	"Page size"
+//	@Param			q			query		string							false	"Search text"
+//	@Param			status		query		[]string						false	"processing, applied, failed or cancelled"
+//	@Param			company		query		string							false	"Part of the company name"
+//	@Param			ats			query		[]string						false	"ATS type, e.g. greenhouse or lever"
+//	@Param			createdFrom	query		string							false	"Earliest creation time (RFC 3339)"
+//	@Param			createdTo	query		string							false	"Latest creation time (RFC 3339)"
+//	@Param			updatedFrom	query		string							false	"Earliest update time (RFC 3339)"
+//	@Param			updatedTo	query		string							false	"Latest update time (RFC 3339)"
+//	@Param			sort		query		string							false	"createdAt, updatedAt, jobTitle, companyName, status or relevance"
+//	@Param			order		query		string							false	"asc or desc, defaults to desc"
+//	@Success		200			{object}	FetchAllJobApplicationsResponse	"Job applications"
+//	@Failure		400			{object}	map[string]interface{}			"Bad request"
+//	@Failure		401			{object}	map[string]interface{}			"Unauthorized"
+//	@Failure		500			{object}	map[string]interface{}			"Internal server error"
+//	@Router			/jobs [get]
 func (e *Endpoint) FetchAllJobApplications(c *gin.Context) {
 	userId := c.GetUint("userId")
 	if userId == 0 {
@@ -121,27 +209,87 @@ func (e *Endpoint) FetchAllJobApplications(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var jobApplications []model.JobApplication
-	if err := e.db.Order("created_at DESC").Where("user_id = ?", userId).Limit(request.Limit).Offset((request.Page - 1) * request.Limit).Find(&jobApplications).Error; err != nil {
-		e.logger.Printf("Failed to fetch job applications: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job applications"})
+
+	query := e.db.Model(&model.JobApplication{}).Where("job_application.id_user = ? AND job_application.deleted_at IS NULL", userId)
+
+	// Text made only of punctuation would otherwise quietly match everything
+	search := ftsQuery(request.Q)
+	if request.Q != "" && search == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search text must contain at least one letter or digit"})
 		return
 	}
+	if search != "" {
+		query = query.
+			Joins("JOIN job_application_fts ON job_application_fts.rowid = job_application.id_job_application").
+			Where("job_application_fts MATCH ?", search)
+	}
+	if len(request.Status) > 0 {
+		query = query.Where("job_application.status IN ?", request.Status)
+	}
+	if request.Company != "" {
+		query = query.Where("LOWER(job_application.company_name) LIKE ?", "%"+strings.ToLower(request.Company)+"%")
+	}
+	if len(request.Ats) > 0 {
+		query = query.Where("job_application.ats_type IN ?", request.Ats)
+	}
+	if request.CreatedFrom != nil {
+		query = query.Where("job_application.created_at >= ?", *request.CreatedFrom)
+	}
+	if request.CreatedTo != nil {
+		query = query.Where("job_application.created_at <= ?", *request.CreatedTo)
+	}
+	if request.UpdatedFrom != nil {
+		query = query.Where("job_application.updated_at >= ?", *request.UpdatedFrom)
+	}
+	if request.UpdatedTo != nil {
+		query = query.Where("job_application.updated_at <= ?", *request.UpdatedTo)
+	}
 
 	var total int64
-	if err := e.db.Model(&model.JobApplication{}).Where("user_id = ?", userId).Count(&total).Error; err != nil {
+	if err := query.Count(&total).Error; err != nil {
 		e.logger.Printf("Failed to fetch total job applications: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch total job applications"})
 		return
 	}
+
+	sort := request.Sort
+	if sort == "" {
+		sort = "createdAt"
+		if search != "" {
+			sort = "relevance"
+		}
+	}
+	direction := "DESC"
+	if request.Order == "asc" {
+		direction = "ASC"
+	}
+	if sort == "relevance" && search != "" {
+		// bm25 scores better matches lower
+		query = query.Order("bm25(job_application_fts)")
+	} else if column, ok := jobApplicationSortColumns[sort]; ok {
+		query = query.Order(column + " " + direction)
+	} else {
+		query = query.Order("job_application.created_at " + direction)
+	}
+
+	var jobApplications []model.JobApplication
+	if err := query.Select("job_application.*").Order("job_application.id_job_application " + direction).Limit(request.Limit).Offset((request.Page - 1) * request.Limit).Find(&jobApplications).Error; err != nil {
+		e.logger.Printf("Failed to fetch job applications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job applications"})
+		return
+	}
+
 	applications := make([]JobApplication, 0, len(jobApplications))
 	for _, jobApplication := range jobApplications {
 		applications = append(applications, JobApplication{
-			Id:        jobApplication.IdExternal.String(),
-			Url:       jobApplication.Url,
-			Status:    jobApplication.Status,
-			CreatedAt: jobApplication.CreatedAt,
-			UpdatedAt: jobApplication.UpdatedAt,
+			Id:          jobApplication.IdExternal.String(),
+			Url:         jobApplication.Url,
+			Status:      jobApplication.Status,
+			JobTitle:    jobApplication.JobTitle,
+			CompanyName: jobApplication.CompanyName,
+			AtsType:     jobApplication.AtsType,
+			CreatedAt:   jobApplication.CreatedAt,
+			UpdatedAt:   jobApplication.UpdatedAt,
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"data": FetchAllJobApplicationsResponse{
@@ -151,3 +299,18 @@ func (e *Endpoint) FetchAllJobApplications(c *gin.Context) {
 		Limit: request.Limit,
 	}})
 }
+
+// ftsQuery turns free text into an FTS5 query that matches every word as a
+// prefix. Only letters and digits are kept, so operators and quotes typed by
+// the user cannot change the query's meaning.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,195 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/temporal"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxApplicationAttempts caps the workflow runs per application, the first
+// one included. Attempts whose run Temporal refused to start do not count.
+const maxApplicationAttempts = 3
+
+var errNotRetryable = errors.New("job application is no longer failed")
+
+// RetryJobApplication godoc
+//
+//	@Summary		Retry a failed job application
+//	@Description	Starts a new workflow run for a failed application with the currently active resume. Each application gets at most three attempts; attempts that could not be started do not count.
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string					true	"Job application id"
+//	@Success		202	{object}	map[string]interface{}	"Retry started"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		409	{object}	map[string]interface{}	"Not failed, out of attempts or cancelled before it started"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/jobs/{id}/retry [post]
+func (e *Endpoint) RetryJobApplication(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var jobApplication model.JobApplication
+	if err := e.db.Where("id_external = ? AND id_user = ? AND deleted_at IS NULL", c.Param("id"), userId).First(&jobApplication).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job application not found"})
+			return
+		}
+		e.logger.Printf("Failed to fetch job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job application"})
+		return
+	}
+
+	if jobApplication.Status != model.JobApplicationStatusFailed {
+		respondNotRetryable(c, jobApplication.Status)
+		return
+	}
+
+	var attempts, startedAttempts int64
+	if err := e.db.Model(&model.JobApplicationAttempt{}).Where("id_job_application = ?", jobApplication.IdJobApplication).Count(&attempts).Error; err != nil {
+		e.logger.Printf("Failed to count job application attempts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job application"})
+		return
+	}
+	if err := e.db.Model(&model.JobApplicationAttempt{}).Where("id_job_application = ? AND workflow_id IS NOT NULL", jobApplication.IdJobApplication).Count(&startedAttempts).Error; err != nil {
+		e.logger.Printf("Failed to count job application attempts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job application"})
+		return
+	}
+	if startedAttempts >= maxApplicationAttempts {
+		e.recordRetry(c, jobApplication, model.AuditOutcomeFailure, int(attempts), "limit_reached")
+		c.JSON(http.StatusConflict, gin.H{"error": "This application has been retried too many times", "code": "RETRY_LIMIT_REACHED", "maxAttempts": maxApplicationAttempts})
+		return
+	}
+
+	resumeId, err := e.activeResumeId(userId)
+	if err != nil {
+		e.logger.Printf("Failed to retry job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job application"})
+		return
+	}
+
+	previousFailure := e.failureReason(c.Request.Context(), jobApplication)
+	now := time.Now()
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		// Guards against a concurrent retry claiming the same failed run
+		result := tx.Model(&model.JobApplication{}).
+			Where("id_job_application = ? AND status = ?", jobApplication.IdJobApplication, model.JobApplicationStatusFailed).
+			Updates(map[string]interface{}{
+				"status":              model.JobApplicationStatusPending,
+				"failure_reason":      nil,
+				"cancel_requested_at": nil,
+				"id_resume":           resumeId,
+				"workflow_id":         nil,
+				"run_id":              nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNotRetryable
+		}
+
+		return tx.Model(&model.JobApplicationAttempt{}).
+			Where("id_job_application = ? AND finished_at IS NULL", jobApplication.IdJobApplication).
+			Updates(map[string]interface{}{
+				"outcome":        model.JobApplicationStatusFailed,
+				"failure_reason": previousFailure,
+				"finished_at":    now,
+			}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errNotRetryable) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This application is already being retried", "code": "NOT_RETRYABLE"})
+			return
+		}
+		e.logger.Printf("Failed to retry job application: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job application"})
+		return
+	}
+
+	jobApplication.Status = model.JobApplicationStatusPending
+	jobApplication.ResumeId = resumeId
+	number := int(attempts) + 1
+	if err := e.startAttempt(&jobApplication, number); err != nil {
+		if errors.Is(err, errAttemptAbandoned) {
+			e.recordRetry(c, jobApplication, model.AuditOutcomeFailure, number, "cancelled")
+			respondAbandoned(c)
+			return
+		}
+		e.logger.Printf("Failed to retry job application: %v", err)
+		e.recordRetry(c, jobApplication, model.AuditOutcomeFailure, number, "workflow_start_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start job application process"})
+		return
+	}
+
+	e.recordRetry(c, jobApplication, model.AuditOutcomeSuccess, number, "")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Job application retry started",
+		"data": gin.H{
+			"attempt":           number,
+			"remainingAttempts": maxApplicationAttempts - int(startedAttempts) - 1,
+		},
+	})
+}
+
+// failureReason explains why the application's latest run failed, asking
+// Temporal when the worker did not record a reason
+func (e *Endpoint) failureReason(ctx context.Context, jobApplication model.JobApplication) string {
+	if jobApplication.FailureReason != "" || jobApplication.WorkflowId == "" {
+		return jobApplication.FailureReason
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, workflowHistoryTimeout)
+	defer cancel()
+	workflowEvents, err := temporal.WorkflowHistory(ctx, e.temporalClient, jobApplication.WorkflowId, jobApplication.RunId)
+	if err != nil {
+		e.logger.Printf("Failed to fetch workflow history for %s: %v", jobApplication.WorkflowId, err)
+		return ""
+	}
+	e.logFailureDetails(jobApplication.WorkflowId, workflowEvents)
+	return temporal.FailureReason(workflowEvents)
+}
+
+// logFailureDetails logs the raw failure messages of a workflow run, which
+// users only see as one of the temporal Reason constants
+func (e *Endpoint) logFailureDetails(workflowId string, workflowEvents []temporal.WorkflowEvent) {
+	for _, event := range workflowEvents {
+		if event.Detail != "" {
+			e.logger.Printf("Workflow %s %s %s: %s", workflowId, event.Type, event.Step, event.Detail)
+		}
+	}
+}
+
+func respondNotRetryable(c *gin.Context, status model.JobApplicationStatus) {
+	if status == model.JobApplicationStatusApplied {
+		c.JSON(http.StatusConflict, gin.H{"error": "This application has already been submitted", "code": "ALREADY_APPLIED"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "Only failed applications can be retried", "code": "NOT_RETRYABLE", "status": status})
+}
+
+func (e *Endpoint) recordRetry(c *gin.Context, jobApplication model.JobApplication, outcome model.AuditOutcome, attempt int, reason string) {
+	metadata := map[string]interface{}{"url": jobApplication.Url, "attempt": attempt}
+	if reason != "" {
+		metadata["reason"] = reason
+	}
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionJobRetry,
+		Outcome:    outcome,
+		TargetType: "job_application",
+		TargetId:   jobApplication.IdExternal.String(),
+		Metadata:   metadata,
+	})
+}
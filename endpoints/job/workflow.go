@@ -0,0 +1,143 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"gorm.io/gorm"
+)
+
+// Shown to the user when Temporal refuses a new workflow run
+const workflowStartFailureReason = "The application could not be started, please try again"
+
+// errAttemptAbandoned is returned by startAttempt for an application that was
+// cancelled or deleted before its workflow started
+var errAttemptAbandoned = errors.New("job application was stopped before its workflow started")
+
+// activeResumeId returns the resume applications are currently made with, or
+// nil when the user has none
+func (e *Endpoint) activeResumeId(userId uint) (*uint, error) {
+	var activeResume model.Resume
+	err := e.db.Where("id_user = ? AND is_active = ? AND deleted_at IS NULL", userId, true).Order("created_at DESC").First(&activeResume).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find active resume: %w", err)
+	}
+	return &activeResume.IdResume, nil
+}
+
+// startAttempt starts a workflow run for jobApplication and records it as
+// attempt number. When the run cannot be started the application is marked
+// failed, so it can be retried. It returns errAttemptAbandoned without
+// starting anything once the application was cancelled or deleted.
+func (e *Endpoint) startAttempt(jobApplication *model.JobApplication, number int) error {
+	attempt := model.JobApplicationAttempt{
+		JobApplicationId: jobApplication.IdJobApplication,
+		UserId:           jobApplication.UserId,
+		Number:           number,
+		ResumeId:         jobApplication.ResumeId,
+		StartedAt:        time.Now(),
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                       fmt.Sprintf("job-application-%s-%d", jobApplication.Url, time.Now().Unix()),
+		TaskQueue:                string(e.taskQueueName),
+		WorkflowExecutionTimeout: workflowExecutionTimeout,
+		WorkflowTaskTimeout:      1 * time.Minute,
+	}
+
+	// The workflow id is reserved only while nobody has asked to stop the
+	// application, so a cancel or account deletion that comes later knows a
+	// run may be on its way and one that came earlier prevents it
+	result := e.db.Model(&model.JobApplication{}).
+		Where("id_job_application = ? AND status = ? AND cancel_requested_at IS NULL AND deleted_at IS NULL", jobApplication.IdJobApplication, model.JobApplicationStatusPending).
+		Updates(map[string]interface{}{"workflow_id": workflowOptions.ID, "run_id": nil})
+	if result.Error != nil {
+		return fmt.Errorf("failed to reserve job application workflow: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errAttemptAbandoned
+	}
+	jobApplication.WorkflowId = workflowOptions.ID
+	jobApplication.RunId = ""
+
+	workflowInput := JobApplicationWorkflowInput{
+		Url:              jobApplication.Url,
+		IdJobApplication: jobApplication.IdJobApplication,
+		IdUser:           jobApplication.UserId,
+	}
+	if jobApplication.ResumeId != nil {
+		workflowInput.IdResume = *jobApplication.ResumeId
+	}
+
+	workflowRun, startErr := e.temporalClient.ExecuteWorkflow(context.Background(), workflowOptions, "JobApplicationWorkflow", workflowInput)
+	if startErr != nil {
+		now := time.Now()
+		attempt.Outcome = model.JobApplicationStatusFailed
+		attempt.FailureReason = workflowStartFailureReason
+		attempt.FinishedAt = &now
+		if err := e.db.Create(&attempt).Error; err != nil {
+			e.logger.Printf("Failed to record job application attempt: %v", err)
+		}
+		if err := e.db.Model(jobApplication).Updates(map[string]interface{}{
+			"status":         model.JobApplicationStatusFailed,
+			"failure_reason": workflowStartFailureReason,
+			"workflow_id":    nil,
+			"run_id":         nil,
+		}).Error; err != nil {
+			e.logger.Printf("Failed to mark job application as failed: %v", err)
+		}
+		jobApplication.Status = model.JobApplicationStatusFailed
+		jobApplication.FailureReason = workflowStartFailureReason
+		jobApplication.WorkflowId = ""
+		// A cancel that came in meanwhile found nothing to stop yet
+		if e.stopRequested(*jobApplication) {
+			jobApplication.Status = e.finishCancellation(context.Background(), *jobApplication, enumspb.WORKFLOW_EXECUTION_STATUS_CANCELED)
+		}
+		return fmt.Errorf("failed to start job application workflow: %w", startErr)
+	}
+
+	attempt.WorkflowId = workflowRun.GetID()
+	attempt.RunId = workflowRun.GetRunID()
+	if err := e.db.Create(&attempt).Error; err != nil {
+		e.logger.Printf("Failed to record job application attempt: %v", err)
+	}
+	if err := e.db.Model(jobApplication).Update("run_id", workflowRun.GetRunID()).Error; err != nil {
+		e.logger.Printf("Failed to record job application workflow: %v", err)
+	}
+	jobApplication.RunId = workflowRun.GetRunID()
+
+	// A cancel or deletion that came in while the run was starting may have
+	// found nothing to stop yet, so the run is stopped here instead
+	if e.stopRequested(*jobApplication) {
+		err := e.temporalClient.CancelWorkflow(context.Background(), jobApplication.WorkflowId, jobApplication.RunId)
+		var notFound *serviceerror.NotFound
+		if err != nil && !errors.As(err, &notFound) {
+			e.logger.Printf("Failed to cancel workflow %s: %v", jobApplication.WorkflowId, err)
+		}
+		go e.awaitCancellation(*jobApplication)
+	}
+	return nil
+}
+
+// stopRequested reports whether the application has been cancelled or
+// deleted since it was loaded
+func (e *Endpoint) stopRequested(jobApplication model.JobApplication) bool {
+	var count int64
+	err := e.db.Model(&model.JobApplication{}).
+		Where("id_job_application = ? AND (cancel_requested_at IS NOT NULL OR deleted_at IS NOT NULL)", jobApplication.IdJobApplication).
+		Count(&count).Error
+	if err != nil {
+		e.logger.Printf("Failed to check job application %s for a cancellation: %v", jobApplication.IdExternal, err)
+		return false
+	}
+	return count > 0
+}
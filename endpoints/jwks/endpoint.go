@@ -0,0 +1,29 @@
+package jwks
+
+import (
+	"net/http"
+
+	"github.com/SomtoJF/iris-api/pkg/signingkeys"
+	"github.com/gin-gonic/gin"
+)
+
+type Endpoint struct {
+	keys *signingkeys.Manager
+}
+
+func NewEndpoint(keys *signingkeys.Manager) *Endpoint {
+	return &Endpoint{keys: keys}
+}
+
+// GetJWKS godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Public keys for verifying access tokens issued by this API, including keys scheduled for rotation
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Key set"
+//	@Router			/.well-known/jwks.json [get]
+func (e *Endpoint) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": e.keys.JWKS()})
+}
@@ -0,0 +1,249 @@
+package legal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/consent"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Endpoint struct {
+	db       *gorm.DB
+	consents *consent.Service
+	audit    *audit.Recorder
+	logger   *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, consents *consent.Service, auditRecorder *audit.Recorder, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, consents: consents, audit: auditRecorder, logger: logger}
+}
+
+type AcceptDocumentsRequest struct {
+	// Ids of the current legal documents being accepted
+	Documents []string `json:"documents" binding:"required,min=1,dive,uuid"`
+}
+
+type PublishDocumentRequest struct {
+	Kind    string `json:"kind" binding:"required,oneof=terms privacy"`
+	Version string `json:"version" binding:"required,max=50"`
+	Title   string `json:"title" binding:"required,max=255"`
+	Url     string `json:"url" binding:"required,url"`
+	// Omit to require acceptance immediately
+	EffectiveAt *time.Time `json:"effectiveAt"`
+}
+
+type ConsentDTO struct {
+	Id         string              `json:"id"`
+	Document   consent.DocumentDTO `json:"document"`
+	AcceptedAt time.Time           `json:"acceptedAt"`
+}
+
+// FetchCurrentDocuments godoc
+//
+//	@Summary		Current legal documents
+//	@Description	Lists the latest effective version of each legal document. Their ids are sent as acceptedDocuments on signup.
+//	@Tags			legal
+//	@Produce		json
+//	@Success		200	{object}	[]consent.DocumentDTO	"Current documents"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/legal/documents [get]
+func (e *Endpoint) FetchCurrentDocuments(c *gin.Context) {
+	documents, err := e.consents.Current()
+	if err != nil {
+		e.logger.Printf("Failed to fetch current legal documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch legal documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": consent.ToDTOs(documents)})
+}
+
+// FetchConsents godoc
+//
+//	@Summary		List my consents
+//	@Description	Lists every legal document version the current user has accepted, newest first, and the current versions still outstanding
+//	@Tags			legal
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Consents"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/consents [get]
+func (e *Endpoint) FetchConsents(c *gin.Context) {
+	userId := c.GetUint("userId")
+
+	var consents []model.Consent
+	if err := e.db.Preload("LegalDocument").Where("id_user = ?", userId).Order("accepted_at DESC").Find(&consents).Error; err != nil {
+		e.logger.Printf("Failed to fetch consents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consents"})
+		return
+	}
+
+	outstanding, err := e.consents.Outstanding(userId)
+	if err != nil {
+		e.logger.Printf("Failed to fetch outstanding legal documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consents"})
+		return
+	}
+
+	consentDTOs := make([]ConsentDTO, 0, len(consents))
+	for _, record := range consents {
+		consentDTOs = append(consentDTOs, ConsentDTO{
+			Id:         record.IdExternal.String(),
+			Document:   consent.ToDTO(record.LegalDocument),
+			AcceptedAt: record.AcceptedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"consents":    consentDTOs,
+		"outstanding": consent.ToDTOs(outstanding),
+	}})
+}
+
+// AcceptDocuments godoc
+//
+//	@Summary		Accept legal documents
+//	@Description	Records the current user's acceptance of current legal document versions, lifting the CONSENT_REQUIRED block once none are outstanding
+//	@Tags			legal
+//	@Accept			json
+//	@Produce		json
+//	@Param			acceptDocumentsRequest	body		AcceptDocumentsRequest	true	"Documents to accept"
+//	@Success		200						{object}	map[string]interface{}	"Documents accepted"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		409						{object}	map[string]interface{}	"Document has been superseded"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/consents [post]
+func (e *Endpoint) AcceptDocuments(c *gin.Context) {
+	userId := c.GetUint("userId")
+
+	var request AcceptDocumentsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	accepted, _, err := e.consents.Resolve(request.Documents)
+	if err != nil {
+		if errors.Is(err, consent.ErrUnknownDocument) {
+			current, _ := e.consents.Current()
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "These terms have been replaced, please review the latest version",
+				"code":      "CONSENT_OUTDATED",
+				"documents": consent.ToDTOs(current),
+			})
+			return
+		}
+		e.logger.Printf("Failed to resolve legal documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+		return
+	}
+
+	if err := e.consents.Accept(e.db, userId, accepted, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		e.logger.Printf("Failed to record consent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+		return
+	}
+
+	for _, document := range accepted {
+		e.audit.Record(c, audit.Event{
+			Action:     model.AuditActionConsentAccepted,
+			Outcome:    model.AuditOutcomeSuccess,
+			UserId:     audit.UserId(userId),
+			TargetType: "legal_document",
+			TargetId:   document.IdExternal.String(),
+			Metadata:   map[string]interface{}{"kind": document.Kind, "version": document.Version},
+		})
+	}
+
+	// The consent is already recorded, so failing to list what is left only
+	// drops it from the response
+	outstanding, err := e.consents.Outstanding(userId)
+	if err != nil {
+		e.logger.Printf("Failed to fetch outstanding legal documents: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Documents accepted",
+		"data":    gin.H{"outstanding": consent.ToDTOs(outstanding)},
+	})
+}
+
+// FetchDocuments godoc
+//
+//	@Summary		List legal document versions
+//	@Description	Lists every published version of every legal document, including scheduled ones, newest first
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	[]consent.DocumentDTO	"Legal documents"
+//	@Failure		403	{object}	map[string]interface{}	"Forbidden"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/legal-documents [get]
+func (e *Endpoint) FetchDocuments(c *gin.Context) {
+	var documents []model.LegalDocument
+	if err := e.db.Order("effective_at DESC, id_legal_document DESC").Find(&documents).Error; err != nil {
+		e.logger.Printf("Failed to fetch legal documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch legal documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": consent.ToDTOs(documents)})
+}
+
+// PublishDocument godoc
+//
+//	@Summary		Publish a legal document version
+//	@Description	Publishes a new version of the terms or privacy policy. Once it takes effect, every user must accept it before using protected routes again.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			publishDocumentRequest	body		PublishDocumentRequest	true	"Document version"
+//	@Success		201						{object}	consent.DocumentDTO		"Document published"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		403						{object}	map[string]interface{}	"Forbidden"
+//	@Failure		409						{object}	map[string]interface{}	"Version already published"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/admin/legal-documents [post]
+func (e *Endpoint) PublishDocument(c *gin.Context) {
+	var request PublishDocumentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	document := model.LegalDocument{
+		Kind:    model.LegalDocumentKind(request.Kind),
+		Version: strings.TrimSpace(request.Version),
+		Title:   strings.TrimSpace(request.Title),
+		Url:     request.Url,
+	}
+	if request.EffectiveAt != nil {
+		document.EffectiveAt = *request.EffectiveAt
+	}
+
+	if err := e.consents.Publish(&document); err != nil {
+		if errors.Is(err, consent.ErrVersionExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This version has already been published"})
+			return
+		}
+		e.logger.Printf("Failed to publish legal document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish legal document"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionLegalDocumentPublished,
+		Outcome:    model.AuditOutcomeSuccess,
+		TargetType: "legal_document",
+		TargetId:   document.IdExternal.String(),
+		Metadata:   map[string]interface{}{"kind": document.Kind, "version": document.Version},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Document published", "data": consent.ToDTO(document)})
+}
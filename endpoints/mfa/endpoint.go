@@ -0,0 +1,307 @@
+package mfa
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	mfaservice "github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/gin-gonic/gin"
+)
+
+// Disabling TOTP and regenerating recovery codes each take a code, so they
+// share a per-user limit on attempts
+const (
+	codeAttemptLimit  = 5
+	codeAttemptWindow = 15 * time.Minute
+)
+
+type Endpoint struct {
+	mfa         *mfaservice.Service
+	passwords   *passwordhash.Hasher
+	rateLimiter *redispubsub.RedisRateLimiter
+	logger      *log.Logger
+}
+
+func NewEndpoint(mfa *mfaservice.Service, passwords *passwordhash.Hasher, rateLimiter *redispubsub.RedisRateLimiter, logger *log.Logger) *Endpoint {
+	return &Endpoint{mfa: mfa, passwords: passwords, rateLimiter: rateLimiter, logger: logger}
+}
+
+type ConfirmTotpRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTotpRequest struct {
+	// Required unless the account has no password, such as one created
+	// through a social login
+	Password string `json:"password" binding:"max=256"`
+	// Either a code from the authenticator app or a recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type StatusResponse struct {
+	Enabled                bool  `json:"enabled"`
+	RemainingRecoveryCodes int64 `json:"remainingRecoveryCodes"`
+}
+
+type SetupTotpResponse struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// GetStatus godoc
+//
+//	@Summary		Two-factor authentication status
+//	@Description	Reports whether TOTP is enabled and how many recovery codes are left
+//	@Tags			mfa
+//	@Produce		json
+//	@Success		200	{object}	StatusResponse			"Two-factor status"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/mfa [get]
+func (e *Endpoint) GetStatus(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	remaining, err := e.mfa.RemainingRecoveryCodes(user)
+	if err != nil {
+		e.logger.Printf("Failed to count recovery codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": StatusResponse{
+		Enabled:                user.IsTotpEnabled(),
+		RemainingRecoveryCodes: remaining,
+	}})
+}
+
+// SetupTotp godoc
+//
+//	@Summary		Start TOTP enrollment
+//	@Description	Generates a new TOTP secret and otpauth URI; it takes effect once confirmed
+//	@Tags			mfa
+//	@Produce		json
+//	@Success		200	{object}	SetupTotpResponse		"Secret and provisioning URI"
+//	@Failure		400	{object}	map[string]interface{}	"Already enabled"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/mfa/totp/setup [post]
+func (e *Endpoint) SetupTotp(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	secret, uri, err := e.mfa.BeginEnrollment(user)
+	if err != nil {
+		if errors.Is(err, mfaservice.ErrAlreadyEnabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		e.logger.Printf("Failed to start TOTP enrollment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": SetupTotpResponse{Secret: secret, Uri: uri}})
+}
+
+// ConfirmTotp godoc
+//
+//	@Summary		Confirm TOTP enrollment
+//	@Description	Enables TOTP after verifying a code from the authenticator and returns recovery codes
+//	@Tags			mfa
+//	@Accept			json
+//	@Produce		json
+//	@Param			confirmTotpRequest	body		ConfirmTotpRequest		true	"Authenticator code"
+//	@Success		200					{object}	map[string]interface{}	"Recovery codes"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		401					{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/mfa/totp/confirm [post]
+func (e *Endpoint) ConfirmTotp(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request ConfirmTotpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := e.mfa.ConfirmEnrollment(user, request.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfaservice.ErrAlreadyEnabled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		case errors.Is(err, mfaservice.ErrNotEnrolling):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor setup has not been started"})
+		case errors.Is(err, mfaservice.ErrInvalidCode):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication code is incorrect"})
+		default:
+			e.logger.Printf("Failed to confirm TOTP enrollment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication enabled. Store these recovery codes somewhere safe",
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// DisableTotp godoc
+//
+//	@Summary		Disable TOTP
+//	@Description	Turns off two-factor authentication after re-checking the password, if the account has one, and a code
+//	@Tags			mfa
+//	@Accept			json
+//	@Produce		json
+//	@Param			disableTotpRequest	body		DisableTotpRequest		true	"Password and code"
+//	@Success		200					{object}	map[string]interface{}	"Two-factor disabled"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		401					{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		429					{object}	map[string]interface{}	"Too many attempts"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Failure		503					{object}	map[string]interface{}	"Rate limiter unavailable"
+//	@Router			/mfa/totp/disable [post]
+func (e *Endpoint) DisableTotp(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request DisableTotpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !e.allowCodeAttempt(c, user) {
+		return
+	}
+
+	// Accounts without a password rely on the code alone
+	if user.PasswordHash != "" {
+		if match, _, err := e.passwords.Verify(request.Password, user.PasswordHash); err != nil || !match {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+	}
+
+	if !e.verifyCode(c, user, request.Code) {
+		return
+	}
+
+	if err := e.mfa.Disable(user); err != nil {
+		e.logger.Printf("Failed to disable TOTP: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Regenerate recovery codes
+//	@Description	Replaces all recovery codes after verifying a code
+//	@Tags			mfa
+//	@Accept			json
+//	@Produce		json
+//	@Param			regenerateRecoveryCodesRequest	body		RegenerateRecoveryCodesRequest	true	"Authenticator or recovery code"
+//	@Success		200								{object}	map[string]interface{}			"Recovery codes"
+//	@Failure		400								{object}	map[string]interface{}			"Bad request"
+//	@Failure		401								{object}	map[string]interface{}			"Unauthorized"
+//	@Failure		429								{object}	map[string]interface{}			"Too many attempts"
+//	@Failure		500								{object}	map[string]interface{}			"Internal server error"
+//	@Failure		503								{object}	map[string]interface{}			"Rate limiter unavailable"
+//	@Router			/mfa/recovery-codes [post]
+func (e *Endpoint) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request RegenerateRecoveryCodesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !e.allowCodeAttempt(c, user) {
+		return
+	}
+
+	if !e.verifyCode(c, user, request.Code) {
+		return
+	}
+
+	codes, err := e.mfa.RegenerateRecoveryCodes(user)
+	if err != nil {
+		e.logger.Printf("Failed to regenerate recovery codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Recovery codes regenerated. Previous codes no longer work",
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// allowCodeAttempt applies the code attempt limit and writes the error response
+// when it is exceeded. Attempts are refused while the limit cannot be checked,
+// since it is all that stops a code being guessed.
+func (e *Endpoint) allowCodeAttempt(c *gin.Context, user model.User) bool {
+	allowed, retryAfter, err := e.rateLimiter.Allow(c.Request.Context(), "mfa-code", strconv.FormatUint(uint64(user.IdUser), 10), codeAttemptLimit, codeAttemptWindow)
+	if err != nil {
+		e.logger.Printf("Failed to check rate limit: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor settings are temporarily unavailable, please try again later"})
+		return false
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, please try again later"})
+		return false
+	}
+	return true
+}
+
+// verifyCode checks a second factor and writes the error response if it fails
+func (e *Endpoint) verifyCode(c *gin.Context, user model.User, code string) bool {
+	if err := e.mfa.Verify(user, code); err != nil {
+		switch {
+		case errors.Is(err, mfaservice.ErrNotEnabled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		case errors.Is(err, mfaservice.ErrInvalidCode):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication code is incorrect"})
+		default:
+			e.logger.Printf("Failed to verify authentication code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify authentication code"})
+		}
+		return false
+	}
+	return true
+}
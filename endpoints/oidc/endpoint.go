@@ -0,0 +1,376 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/accountclaim"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/invite"
+	"github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/oidc"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const stateCookieName = "OIDC_State"
+
+var (
+	errEmailRequired  = errors.New("identity provider did not share a verified email address")
+	errInviteRequired = errors.New("new accounts require an invite code")
+)
+
+type Endpoint struct {
+	db        *gorm.DB
+	sessions  *session.Manager
+	tokens    *onetimetoken.Store
+	providers map[string]oidc.Provider
+	states    *oidc.StateStore
+	audit     *audit.Recorder
+	invites   *invite.Service
+	logger    *log.Logger
+	clientUrl string
+}
+
+func NewEndpoint(db *gorm.DB, sessions *session.Manager, tokens *onetimetoken.Store, providers map[string]oidc.Provider, states *oidc.StateStore, auditRecorder *audit.Recorder, invites *invite.Service, logger *log.Logger, clientUrl string) *Endpoint {
+	return &Endpoint{db: db, sessions: sessions, tokens: tokens, providers: providers, states: states, audit: auditRecorder, invites: invites, logger: logger, clientUrl: clientUrl}
+}
+
+type LinkedIdentityDTO struct {
+	Id         string     `json:"id"`
+	Provider   string     `json:"provider"`
+	Email      string     `json:"email"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Login godoc
+//
+//	@Summary		Start social login
+//	@Description	Redirects to the identity provider using the authorization code flow with PKCE
+//	@Tags			auth
+//	@Param			provider	path	string	true	"Provider name, e.g. google or github"
+//	@Success		302
+//	@Failure		404	{object}	map[string]interface{}	"Unknown provider"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/auth/oidc/{provider}/login [get]
+func (e *Endpoint) Login(c *gin.Context) {
+	provider, ok := e.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, err := securetoken.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := securetoken.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authUrl, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		e.logger.Printf("Failed to build %s authorization URL: %v", provider.Name(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	loginState := oidc.LoginState{Provider: provider.Name(), Nonce: nonce, CodeVerifier: verifier}
+	if err := e.states.Save(c.Request.Context(), state, loginState); err != nil {
+		e.logger.Printf("Failed to save login state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// Binds the login to this browser so a victim cannot be made to finish an
+	// attacker's login
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidc.StateTTL.Seconds()),
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// Callback godoc
+//
+//	@Summary		Finish social login
+//	@Description	Handles the identity provider redirect, links the identity to a user and starts a session
+//	@Tags			auth
+//	@Param			provider	path	string	true	"Provider name"
+//	@Param			code		query	string	true	"Authorization code"
+//	@Param			state		query	string	true	"Login state"
+//	@Success		302
+//	@Router			/auth/oidc/{provider}/callback [get]
+func (e *Endpoint) Callback(c *gin.Context) {
+	provider, ok := e.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{Name: stateCookieName, Value: "", Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+
+	if providerError := c.Query("error"); providerError != "" {
+		e.redirectWithError(c, "provider_error")
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, err := c.Cookie(stateCookieName)
+	if err != nil || state == "" || !securetoken.Equal(state, cookieState) {
+		e.redirectWithError(c, "invalid_state")
+		return
+	}
+
+	loginState, err := e.states.Take(c.Request.Context(), state)
+	if err != nil {
+		if !errors.Is(err, oidc.ErrInvalidState) {
+			e.logger.Printf("Failed to load login state: %v", err)
+		}
+		e.redirectWithError(c, "invalid_state")
+		return
+	}
+	if loginState.Provider != provider.Name() {
+		e.redirectWithError(c, "invalid_state")
+		return
+	}
+
+	identity, err := provider.Authenticate(c.Request.Context(), c.Query("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		e.logger.Printf("Failed to authenticate with %s: %v", provider.Name(), err)
+		e.redirectWithError(c, "authentication_failed")
+		return
+	}
+
+	user, err := e.resolveUser(identity)
+	if err != nil {
+		if errors.Is(err, errEmailRequired) {
+			e.redirectWithError(c, "email_required")
+			return
+		}
+		if errors.Is(err, errInviteRequired) {
+			e.redirectWithError(c, "invite_required")
+			return
+		}
+		e.logger.Printf("Failed to link %s identity: %v", provider.Name(), err)
+		e.redirectWithError(c, "authentication_failed")
+		return
+	}
+
+	// Social login only replaces the password, not the second factor
+	if user.IsTotpEnabled() {
+		challenge, err := e.tokens.Issue(user.IdUser, model.UserTokenPurposeLoginChallenge, mfa.LoginChallengeTTL, "oidc")
+		if err != nil {
+			e.logger.Printf("Failed to issue login challenge: %v", err)
+			e.redirectWithError(c, "authentication_failed")
+			return
+		}
+		c.Redirect(http.StatusFound, fmt.Sprintf("%s/login/mfa?challenge=%s", strings.TrimSuffix(e.clientUrl, "/"), url.QueryEscape(challenge)))
+		return
+	}
+
+	newSession, err := e.sessions.Start(c, *user)
+	if err != nil {
+		e.logger.Printf("Failed to start session: %v", err)
+		e.redirectWithError(c, "authentication_failed")
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionLogin,
+		Outcome:    model.AuditOutcomeSuccess,
+		UserId:     audit.UserId(user.IdUser),
+		TargetType: "session",
+		TargetId:   newSession.IdExternal.String(),
+		Metadata:   map[string]interface{}{"method": "oidc", "provider": provider.Name()},
+	})
+
+	c.Redirect(http.StatusFound, strings.TrimSuffix(e.clientUrl, "/")+"/")
+}
+
+// FetchLinkedIdentities godoc
+//
+//	@Summary		List linked identities
+//	@Description	Lists the external accounts linked to the authenticated user
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]LinkedIdentityDTO		"Linked identities"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/identities [get]
+func (e *Endpoint) FetchLinkedIdentities(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var identities []model.LinkedIdentity
+	if err := e.db.Where("id_user = ?", userId).Order("created_at ASC").Find(&identities).Error; err != nil {
+		e.logger.Printf("Failed to fetch linked identities: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch linked identities"})
+		return
+	}
+
+	identityDTOs := make([]LinkedIdentityDTO, 0, len(identities))
+	for _, identity := range identities {
+		identityDTOs = append(identityDTOs, LinkedIdentityDTO{
+			Id:         identity.IdExternal.String(),
+			Provider:   identity.Provider,
+			Email:      identity.Email,
+			LastUsedAt: identity.LastUsedAt,
+			CreatedAt:  identity.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": identityDTOs})
+}
+
+// UnlinkIdentity godoc
+//
+//	@Summary		Unlink an identity
+//	@Description	Removes a linked external account, as long as another way to sign in remains
+//	@Tags			users
+//	@Param			id	path		string					true	"Linked identity id"
+//	@Success		200	{object}	map[string]interface{}	"Identity unlinked"
+//	@Failure		400	{object}	map[string]interface{}	"Last sign-in method"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/identities/{id} [delete]
+func (e *Endpoint) UnlinkIdentity(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var identity model.LinkedIdentity
+	if err := e.db.Where("id_external = ? AND id_user = ?", c.Param("id"), user.IdUser).First(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Linked identity not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find linked identity"})
+		return
+	}
+
+	if user.PasswordHash == "" {
+		var others int64
+		if err := e.db.Model(&model.LinkedIdentity{}).Where("id_user = ? AND id_linked_identity <> ?", user.IdUser, identity.IdLinkedIdentity).Count(&others).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+			return
+		}
+		if others == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set a password before removing your only sign-in method"})
+			return
+		}
+	}
+
+	if err := e.db.Delete(&identity).Error; err != nil {
+		e.logger.Printf("Failed to unlink identity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
+
+// resolveUser finds the user for an external identity. Unknown identities are
+// linked to the account with the same verified email, or get a new account
+// unless signup is invite-only. Linking to an account whose email was never
+// verified claims it, signing out whoever registered it.
+func (e *Endpoint) resolveUser(identity *oidc.Identity) (*model.User, error) {
+	var user model.User
+	var claimed bool
+	now := time.Now()
+
+	err := e.db.Transaction(func(tx *gorm.DB) error {
+		var linked model.LinkedIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+		if err == nil {
+			if err := tx.Where("id_user = ? AND deleted_at IS NULL", linked.UserId).First(&user).Error; err != nil {
+				return err
+			}
+			return tx.Model(&linked).Update("last_used_at", now).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Emails the provider has not verified could belong to anyone, so they
+		// are never used to match or create accounts
+		if identity.Email == "" || !identity.EmailVerified {
+			return errEmailRequired
+		}
+
+		err = tx.Where("LOWER(email) = ? AND deleted_at IS NULL", strings.ToLower(identity.Email)).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if e.invites.Required() {
+				return errInviteRequired
+			}
+			firstName, lastName := identity.GivenName, identity.FamilyName
+			if firstName == "" {
+				firstName, _, _ = strings.Cut(identity.Email, "@")
+			}
+			user = model.User{
+				Email:           identity.Email,
+				FirstName:       firstName,
+				LastName:        lastName,
+				EmailVerifiedAt: &now,
+			}
+			err = tx.Create(&user).Error
+		} else if err == nil && !user.IsEmailVerified() {
+			claimed, err = accountclaim.Claim(tx, &user, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&model.LinkedIdentity{
+			UserId:     user.IdUser,
+			Provider:   identity.Provider,
+			Subject:    identity.Subject,
+			Email:      identity.Email,
+			LastUsedAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claimed {
+		if err := e.sessions.RevokeAllForUser(user.IdUser, 0, model.SessionRevokedAccountClaimed); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (e *Endpoint) redirectWithError(c *gin.Context, code string) {
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s/login?error=%s", strings.TrimSuffix(e.clientUrl, "/"), url.QueryEscape(code)))
+}
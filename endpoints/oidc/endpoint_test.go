@@ -0,0 +1,165 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/oidc"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stubProvider fails the test if the callback gets as far as the provider
+type stubProvider struct {
+	t *testing.T
+}
+
+func (p stubProvider) Name() string { return "mock" }
+
+func (p stubProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	p.t.Fatal("AuthCodeURL was called")
+	return "", nil
+}
+
+func (p stubProvider) Authenticate(ctx context.Context, code string, codeVerifier string, nonce string) (*oidc.Identity, error) {
+	p.t.Fatal("Authenticate was called")
+	return nil, nil
+}
+
+func newTestEndpoint(t *testing.T, db *gorm.DB) *Endpoint {
+	providers := map[string]oidc.Provider{"mock": stubProvider{t: t}}
+	return NewEndpoint(db, session.NewManager(db, nil, ""), nil, providers, nil, nil, nil, log.New(io.Discard, "", 0), "https://app.example.com")
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// Every connection to :memory: would get its own empty database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&model.User{}, &model.LinkedIdentity{}, &model.Session{}, &model.RecoveryCode{}, &model.WebAuthnCredential{}, &model.PersonalAccessToken{}, &model.UserToken{})
+	if err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return db
+}
+
+func TestCallbackRejectsStateMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	endpoint := newTestEndpoint(t, nil)
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{"different state", "?code=code&state=attacker", "victim"},
+		{"no cookie", "?code=code&state=attacker", ""},
+		{"no state", "?code=code", "victim"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback"+test.query, nil)
+			if test.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: stateCookieName, Value: test.cookie})
+			}
+			c.Params = gin.Params{{Key: "provider", Value: "mock"}}
+
+			endpoint.Callback(c)
+
+			if recorder.Code != http.StatusFound {
+				t.Fatalf("status is %d, want %d", recorder.Code, http.StatusFound)
+			}
+			if location := recorder.Header().Get("Location"); location != "https://app.example.com/login?error=invalid_state" {
+				t.Fatalf("redirected to %q", location)
+			}
+		})
+	}
+}
+
+func TestResolveUserRejectsUnverifiedEmail(t *testing.T) {
+	db := newTestDB(t)
+	endpoint := newTestEndpoint(t, db)
+
+	existing := model.User{IdExternal: uuid.New(), Email: "ada@example.com", FirstName: "Ada", PasswordHash: "hash"}
+	if err := db.Create(&existing).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	_, err := endpoint.resolveUser(&oidc.Identity{Provider: "mock", Subject: "subject-1", Email: "ada@example.com", EmailVerified: false})
+	if !errors.Is(err, errEmailRequired) {
+		t.Fatalf("expected errEmailRequired, got %v", err)
+	}
+
+	var linked int64
+	if err := db.Model(&model.LinkedIdentity{}).Count(&linked).Error; err != nil {
+		t.Fatalf("counting linked identities: %v", err)
+	}
+	if linked != 0 {
+		t.Fatalf("an identity with an unverified email was linked")
+	}
+}
+
+func TestResolveUserClaimsUnverifiedAccount(t *testing.T) {
+	db := newTestDB(t)
+	endpoint := newTestEndpoint(t, db)
+
+	// Registered by someone who does not own the address
+	squatter := model.User{IdExternal: uuid.New(), Email: "ada@example.com", FirstName: "Mallory", PasswordHash: "hash"}
+	if err := db.Create(&squatter).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	squatterSession := model.Session{IdExternal: uuid.New(), UserId: squatter.IdUser, RefreshTokenHash: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&squatterSession).Error; err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	user, err := endpoint.resolveUser(&oidc.Identity{Provider: "mock", Subject: "subject-1", Email: "ada@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("resolveUser: %v", err)
+	}
+	if user.IdUser != squatter.IdUser {
+		t.Fatalf("resolved user %d, want %d", user.IdUser, squatter.IdUser)
+	}
+
+	var claimed model.User
+	if err := db.First(&claimed, squatter.IdUser).Error; err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	if claimed.PasswordHash != "" {
+		t.Fatal("the password set before the email was verified still works")
+	}
+	if !claimed.IsEmailVerified() {
+		t.Fatal("the email was not marked verified")
+	}
+
+	var session model.Session
+	if err := db.First(&session, squatterSession.IdSession).Error; err != nil {
+		t.Fatalf("loading session: %v", err)
+	}
+	if session.RevokedAt == nil || session.RevokedReason != model.SessionRevokedAccountClaimed {
+		t.Fatalf("the earlier session was not revoked: %+v", session)
+	}
+}
@@ -0,0 +1,401 @@
+package passkey
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/SomtoJF/iris-api/pkg/webauthn"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxPasskeysPerUser = 20
+
+	loginOptionsIPLimit  = 30
+	loginOptionsIPWindow = time.Hour
+)
+
+type Endpoint struct {
+	db           *gorm.DB
+	relyingParty *webauthn.RelyingParty
+	sessions     *session.Manager
+	rateLimiter  *redispubsub.RedisRateLimiter
+	audit        *audit.Recorder
+	logger       *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, relyingParty *webauthn.RelyingParty, sessions *session.Manager, rateLimiter *redispubsub.RedisRateLimiter, auditRecorder *audit.Recorder, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, relyingParty: relyingParty, sessions: sessions, rateLimiter: rateLimiter, audit: auditRecorder, logger: logger}
+}
+
+type RegisterPasskeyRequest struct {
+	Name       string                        `json:"name" binding:"required,max=100"`
+	Credential webauthn.RegistrationResponse `json:"credential" binding:"required"`
+}
+
+type LoginOptionsRequest struct {
+	// Optional, narrows the ceremony to the account's passkeys
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+type LoginRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential" binding:"required"`
+}
+
+type PasskeyDTO struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	BackedUp   bool       `json:"backedUp"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// RegistrationOptions godoc
+//
+//	@Summary		Start passkey registration
+//	@Description	Returns options to pass to navigator.credentials.create(). They expire after five minutes.
+//	@Tags			passkeys
+//	@Produce		json
+//	@Success		200	{object}	webauthn.CreationOptions	"Creation options"
+//	@Failure		400	{object}	map[string]interface{}		"Passkey limit reached"
+//	@Failure		401	{object}	map[string]interface{}		"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}		"Internal server error"
+//	@Router			/passkeys/register/options [post]
+func (e *Endpoint) RegistrationOptions(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var existing []model.WebAuthnCredential
+	if err := e.db.Where("id_user = ?", user.IdUser).Find(&existing).Error; err != nil {
+		e.logger.Printf("Failed to fetch passkeys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+	if len(existing) >= maxPasskeysPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey limit reached, remove an existing passkey first"})
+		return
+	}
+
+	options, err := e.relyingParty.BeginRegistration(c.Request.Context(), user, existing)
+	if err != nil {
+		e.logger.Printf("Failed to start passkey registration: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": options})
+}
+
+// RegisterPasskey godoc
+//
+//	@Summary		Finish passkey registration
+//	@Description	Verifies the authenticator's response to the registration options and stores the passkey
+//	@Tags			passkeys
+//	@Accept			json
+//	@Produce		json
+//	@Param			registerPasskeyRequest	body		RegisterPasskeyRequest	true	"Passkey name and credential"
+//	@Success		201						{object}	PasskeyDTO				"Registered passkey"
+//	@Failure		400						{object}	map[string]interface{}	"Verification failed"
+//	@Failure		401						{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		409						{object}	map[string]interface{}	"Passkey already registered"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/passkeys/register [post]
+func (e *Endpoint) RegisterPasskey(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request RegisterPasskeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := e.relyingParty.FinishRegistration(c.Request.Context(), user, request.Credential)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrInvalidChallenge) || errors.Is(err, webauthn.ErrVerification) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified, please try again"})
+			return
+		}
+		e.logger.Printf("Failed to finish passkey registration: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+
+	var taken int64
+	if err := e.db.Model(&model.WebAuthnCredential{}).Where("credential_id = ?", credential.CredentialId).Count(&taken).Error; err != nil {
+		e.logger.Printf("Failed to check passkey: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+
+	credential.Name = strings.TrimSpace(request.Name)
+	if err := e.db.Create(credential).Error; err != nil {
+		e.logger.Printf("Failed to store passkey: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionPasskeyAdded,
+		Outcome:    model.AuditOutcomeSuccess,
+		TargetType: "passkey",
+		TargetId:   credential.IdExternal.String(),
+		Metadata:   map[string]interface{}{"name": credential.Name, "aaguid": credential.Aaguid},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Passkey registered", "data": toPasskeyDTO(*credential)})
+}
+
+// FetchPasskeys godoc
+//
+//	@Summary		List passkeys
+//	@Description	Lists the passkeys registered to the authenticated user
+//	@Tags			passkeys
+//	@Produce		json
+//	@Success		200	{object}	[]PasskeyDTO			"Passkeys"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/passkeys [get]
+func (e *Endpoint) FetchPasskeys(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var credentials []model.WebAuthnCredential
+	if err := e.db.Where("id_user = ?", userId).Order("created_at DESC").Find(&credentials).Error; err != nil {
+		e.logger.Printf("Failed to fetch passkeys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passkeys"})
+		return
+	}
+
+	passkeyDTOs := make([]PasskeyDTO, 0, len(credentials))
+	for _, credential := range credentials {
+		passkeyDTOs = append(passkeyDTOs, toPasskeyDTO(credential))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": passkeyDTOs})
+}
+
+// DeletePasskey godoc
+//
+//	@Summary		Remove a passkey
+//	@Description	Removes a passkey so it can no longer be used to sign in
+//	@Tags			passkeys
+//	@Param			id	path		string					true	"Passkey id"
+//	@Success		200	{object}	map[string]interface{}	"Passkey removed"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/passkeys/{id} [delete]
+func (e *Endpoint) DeletePasskey(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := e.db.Where("id_external = ? AND id_user = ?", c.Param("id"), userId).Delete(&model.WebAuthnCredential{})
+	if result.Error != nil {
+		e.logger.Printf("Failed to delete passkey: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove passkey"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionPasskeyRemoved,
+		Outcome:    model.AuditOutcomeSuccess,
+		TargetType: "passkey",
+		TargetId:   c.Param("id"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed"})
+}
+
+// LoginOptions godoc
+//
+//	@Summary		Start passkey login
+//	@Description	Returns options to pass to navigator.credentials.get(). Without an email any discoverable passkey can be used.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			loginOptionsRequest	body		LoginOptionsRequest		false	"Account email"
+//	@Success		200					{object}	webauthn.RequestOptions	"Request options"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		429					{object}	map[string]interface{}	"Too many requests"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/login/passkey/options [post]
+func (e *Endpoint) LoginOptions(c *gin.Context) {
+	var request LoginOptionsRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	allowed, retryAfter, err := e.rateLimiter.Allow(c.Request.Context(), "passkey-login:ip", c.ClientIP(), loginOptionsIPLimit, loginOptionsIPWindow)
+	if err != nil {
+		e.logger.Printf("Failed to check rate limit: %v", err)
+	} else if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+		return
+	}
+
+	// An unknown email gets the same response shape with no allowed
+	// credentials, so the endpoint does not reveal which accounts exist
+	var credentials []model.WebAuthnCredential
+	if email := strings.ToLower(strings.TrimSpace(request.Email)); email != "" {
+		err := e.db.Joins("JOIN user ON user.id_user = webauthn_credential.id_user").
+			Where("LOWER(user.email) = ? AND user.deleted_at IS NULL", email).
+			Find(&credentials).Error
+		if err != nil {
+			e.logger.Printf("Failed to fetch passkeys for login: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+			return
+		}
+	}
+
+	options, err := e.relyingParty.BeginLogin(c.Request.Context(), credentials)
+	if err != nil {
+		e.logger.Printf("Failed to start passkey login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": options})
+}
+
+// Login godoc
+//
+//	@Summary		Login with a passkey
+//	@Description	Verifies a passkey assertion and starts a session. Passkeys require user verification, so no second factor is asked for.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			loginRequest	body		LoginRequest			true	"Passkey assertion"
+//	@Success		200				{object}	map[string]interface{}	"success message"
+//	@Failure		400				{object}	map[string]interface{}	"Verification failed"
+//	@Failure		500				{object}	map[string]interface{}	"Internal server error"
+//	@Router			/login/passkey [post]
+func (e *Endpoint) Login(c *gin.Context) {
+	var request LoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var credential model.WebAuthnCredential
+	err := e.db.Preload("User").
+		Where("credential_id = ?", webauthn.CredentialIdFromResponse(request.Credential)).
+		First(&credential).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		e.logger.Printf("Failed to find passkey: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+	if err != nil || credential.User.DeletedAt != nil {
+		e.recordFailure(c, nil, "unknown_credential")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	signCount, err := e.relyingParty.FinishLogin(c.Request.Context(), credential, credential.User, request.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, webauthn.ErrSignCountRegressed):
+			e.recordFailure(c, &credential, "sign_count_regressed")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		case errors.Is(err, webauthn.ErrInvalidChallenge), errors.Is(err, webauthn.ErrVerification):
+			e.recordFailure(c, &credential, "verification_failed")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		default:
+			e.logger.Printf("Failed to verify passkey: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		}
+		return
+	}
+
+	// Conditional so two logins racing on the same counter value cannot both win
+	result := e.db.Model(&model.WebAuthnCredential{}).
+		Where("id_webauthn_credential = ? AND sign_count = ?", credential.IdWebAuthnCredential, credential.SignCount).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": time.Now()})
+	if result.Error != nil {
+		e.logger.Printf("Failed to update passkey: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		e.recordFailure(c, &credential, "sign_count_regressed")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	newSession, err := e.sessions.Start(c, credential.User)
+	if err != nil {
+		e.logger.Printf("Failed to start session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionLogin,
+		Outcome:    model.AuditOutcomeSuccess,
+		UserId:     audit.UserId(credential.UserId),
+		TargetType: "session",
+		TargetId:   newSession.IdExternal.String(),
+		Metadata:   map[string]interface{}{"method": "passkey", "passkey": credential.IdExternal.String()},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+func (e *Endpoint) recordFailure(c *gin.Context, credential *model.WebAuthnCredential, reason string) {
+	event := audit.Event{
+		Action:   model.AuditActionLoginFailed,
+		Outcome:  model.AuditOutcomeFailure,
+		Metadata: map[string]interface{}{"method": "passkey", "reason": reason},
+	}
+	if credential != nil {
+		event.UserId = audit.UserId(credential.UserId)
+		event.TargetType = "passkey"
+		event.TargetId = credential.IdExternal.String()
+	}
+	e.audit.Record(c, event)
+}
+
+func toPasskeyDTO(credential model.WebAuthnCredential) PasskeyDTO {
+	return PasskeyDTO{
+		Id:         credential.IdExternal.String(),
+		Name:       credential.Name,
+		BackedUp:   credential.BackedUp,
+		LastUsedAt: credential.LastUsedAt,
+		CreatedAt:  credential.CreatedAt,
+	}
+}
@@ -0,0 +1,231 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/accountclaim"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	resetTokenTTL = time.Hour
+
+	forgotPasswordEmailLimit = 3
+	forgotPasswordIPLimit    = 10
+	forgotPasswordWindow     = time.Hour
+)
+
+type Endpoint struct {
+	db          *gorm.DB
+	sessions    *session.Manager
+	tokens      *onetimetoken.Store
+	mailer      mailer.Mailer
+	rateLimiter *redispubsub.RedisRateLimiter
+	loginGuard  *redispubsub.RedisLoginGuard
+	passwords   *passwordhash.Hasher
+	audit       *audit.Recorder
+	logger      *log.Logger
+	clientUrl   string
+}
+
+func NewEndpoint(db *gorm.DB, sessions *session.Manager, tokens *onetimetoken.Store, mailer mailer.Mailer, rateLimiter *redispubsub.RedisRateLimiter, loginGuard *redispubsub.RedisLoginGuard, passwords *passwordhash.Hasher, auditRecorder *audit.Recorder, logger *log.Logger, clientUrl string) *Endpoint {
+	return &Endpoint{db: db, sessions: sessions, tokens: tokens, mailer: mailer, rateLimiter: rateLimiter, loginGuard: loginGuard, passwords: passwords, audit: auditRecorder, logger: logger, clientUrl: clientUrl}
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+// ForgotPassword godoc
+//
+//	@Summary		Request a password reset
+//	@Description	Emails a single-use password reset link if an account exists for the address
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			forgotPasswordRequest	body		ForgotPasswordRequest	true	"Account email"
+//	@Success		200						{object}	map[string]interface{}	"Reset email sent if the account exists"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		429						{object}	map[string]interface{}	"Too many requests"
+//	@Router			/password/forgot [post]
+func (e *Endpoint) ForgotPassword(c *gin.Context) {
+	var request ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+
+	if !e.allow(c, "forgot-password:ip", c.ClientIP(), forgotPasswordIPLimit) {
+		return
+	}
+	if !e.allow(c, "forgot-password:email", email, forgotPasswordEmailLimit) {
+		return
+	}
+
+	// Always respond the same way so the endpoint cannot be used to discover accounts
+	response := gin.H{"message": "If an account exists for this email, a password reset link has been sent"}
+
+	var user model.User
+	if err := e.db.Where("LOWER(email) = ? AND deleted_at IS NULL", email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			e.logger.Printf("Failed to look up user for password reset: %v", err)
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Failures past this point are only logged, as an error response would
+	// reveal that the account exists
+	token, err := e.tokens.Issue(user.IdUser, model.UserTokenPurposePasswordReset, resetTokenTTL, "")
+	if err != nil {
+		e.logger.Printf("Failed to issue password reset token: %v", err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	message := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Iris password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Use the link below to choose a new one:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you did not request this, you can ignore this email.\n",
+			user.FirstName, e.resetLink(token), int(resetTokenTTL.Minutes())),
+	}
+	if err := e.mailer.Send(c.Request.Context(), message); err != nil {
+		e.logger.Printf("Failed to send password reset email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Reset password with a token
+//	@Description	Sets a new password using a token from a password reset email and signs out every session
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			resetPasswordRequest	body		ResetPasswordRequest	true	"Reset token and new password"
+//	@Success		200						{object}	map[string]interface{}	"Password updated successfully"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/password/reset [post]
+func (e *Endpoint) ResetPassword(c *gin.Context) {
+	var request ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Checked before the token is consumed so a rejected password does not burn the link
+	if err := e.passwords.Validate(request.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userToken, err := e.tokens.Consume(request.Token, model.UserTokenPurposePasswordReset)
+	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+			return
+		}
+		e.logger.Printf("Failed to consume password reset token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	hashedPassword, err := e.passwords.Hash(request.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash new password"})
+		return
+	}
+
+	var user model.User
+	if err := e.db.Where("id_user = ? AND deleted_at IS NULL", userToken.UserId).First(&user).Error; err != nil {
+		e.logger.Printf("Failed to find user for password reset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Following the reset link proves ownership of the address, so an account
+	// that was never verified is claimed from whoever registered it
+	claimed := false
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		if !user.IsEmailVerified() {
+			var err error
+			if claimed, err = accountclaim.Claim(tx, &user, time.Now()); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&user).Update("password_hash", hashedPassword).Error
+	})
+	if err != nil {
+		e.logger.Printf("Failed to update password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Proving ownership of the email also lifts any login lockout
+	if err := e.loginGuard.Reset(c.Request.Context(), string(model.LockoutScopeAccount), strings.ToLower(user.Email)); err != nil {
+		e.logger.Printf("Failed to reset login lockout: %v", err)
+	}
+
+	revokedReason := model.SessionRevokedPasswordChange
+	if claimed {
+		revokedReason = model.SessionRevokedAccountClaimed
+	}
+	if err := e.sessions.RevokeAllForUser(userToken.UserId, 0, revokedReason); err != nil {
+		e.logger.Printf("Failed to revoke sessions after password reset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out existing sessions"})
+		return
+	}
+
+	e.audit.Record(c, audit.Event{
+		Action:  model.AuditActionPasswordReset,
+		Outcome: model.AuditOutcomeSuccess,
+		UserId:  audit.UserId(user.IdUser),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully. Please login with new password"})
+}
+
+// allow applies a rate limit and writes a 429 response when it is exceeded
+func (e *Endpoint) allow(c *gin.Context, scope string, identifier string, limit int) bool {
+	allowed, retryAfter, err := e.rateLimiter.Allow(c.Request.Context(), scope, identifier, limit, forgotPasswordWindow)
+	if err != nil {
+		// Fail open so a Redis outage does not lock users out of recovery
+		e.logger.Printf("Failed to check rate limit: %v", err)
+		return true
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, please try again later"})
+		return false
+	}
+	return true
+}
+
+func (e *Endpoint) resetLink(token string) string {
+	return fmt.Sprintf("%s/reset-password?token=%s", strings.TrimSuffix(e.clientUrl, "/"), url.QueryEscape(token))
+}
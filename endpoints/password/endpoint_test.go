@@ -0,0 +1,144 @@
+package password
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SomtoJF/iris-api/middleware/verifyauth"
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/SomtoJF/iris-api/pkg/totp"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// Every connection to :memory: would get its own empty database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&model.User{}, &model.Session{}, &model.RecoveryCode{}, &model.WebAuthnCredential{}, &model.PersonalAccessToken{}, &model.UserToken{}, &model.AuditEvent{})
+	if err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return db
+}
+
+func newTestEndpoint(db *gorm.DB) *Endpoint {
+	logger := log.New(io.Discard, "", 0)
+	// Nothing listens here, so clearing the login lockout fails and is only logged
+	loginGuard := redispubsub.NewRedisLoginGuard(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}))
+	passwords := passwordhash.New(passwordhash.Config{
+		Argon2: passwordhash.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+		Policy: passwordhash.DefaultPolicy,
+	})
+	return NewEndpoint(db, session.NewManager(db, nil, ""), onetimetoken.NewStore(db), nil, nil, loginGuard, passwords, audit.NewRecorder(db, logger), logger, "https://app.example.com")
+}
+
+func TestResetPasswordClaimsUnverifiedAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	endpoint := newTestEndpoint(db)
+
+	// Registered by someone who does not own the address, who then set up a
+	// second factor and an access token
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("generating TOTP secret: %v", err)
+	}
+	now := time.Now()
+	squatter := model.User{IdExternal: uuid.New(), Email: "ada@example.com", FirstName: "Mallory", PasswordHash: "hash", TotpSecret: secret, TotpEnabledAt: &now}
+	if err := db.Create(&squatter).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	if err := db.Create(&model.RecoveryCode{UserId: squatter.IdUser, CodeHash: securetoken.Hash("recovery-code")}).Error; err != nil {
+		t.Fatalf("creating recovery code: %v", err)
+	}
+	bearer := verifyauth.PersonalAccessTokenPrefix + "squatter"
+	pat := model.PersonalAccessToken{UserId: squatter.IdUser, Name: "script", Prefix: bearer[:12], TokenHash: securetoken.Hash(bearer), Scopes: string(model.TokenScopeProfileRead)}
+	if err := db.Create(&pat).Error; err != nil {
+		t.Fatalf("creating access token: %v", err)
+	}
+
+	// The owner of the address asks for a reset link
+	resetToken, err := onetimetoken.NewStore(db).Issue(squatter.IdUser, model.UserTokenPurposePasswordReset, time.Hour, "")
+	if err != nil {
+		t.Fatalf("issuing reset token: %v", err)
+	}
+
+	body, _ := json.Marshal(ResetPasswordRequest{Token: resetToken, NewPassword: "correct horse battery"})
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	endpoint.ResetPassword(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	var claimed model.User
+	if err := db.First(&claimed, squatter.IdUser).Error; err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	if !claimed.IsEmailVerified() {
+		t.Fatal("the email was not marked verified")
+	}
+	if match, _, err := endpoint.passwords.Verify("correct horse battery", claimed.PasswordHash); err != nil || !match {
+		t.Fatalf("the new password does not work: match %v, err %v", match, err)
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("generating TOTP code: %v", err)
+	}
+	mfaService := mfa.NewService(db)
+	if err := mfaService.Verify(claimed, code); !errors.Is(err, mfa.ErrNotEnabled) {
+		t.Fatalf("expected the squatter's TOTP to stop working, got %v", err)
+	}
+	var recoveryCodes int64
+	if err := db.Model(&model.RecoveryCode{}).Where("id_user = ?", squatter.IdUser).Count(&recoveryCodes).Error; err != nil {
+		t.Fatalf("counting recovery codes: %v", err)
+	}
+	if recoveryCodes != 0 {
+		t.Fatal("the squatter's recovery codes were kept")
+	}
+
+	router := gin.New()
+	router.GET("/profile", verifyauth.NewMiddleware(db, nil, nil, nil, false).VerifyAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	request.Header.Set("Authorization", "Bearer "+bearer)
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("the squatter's access token still works: status %d", recorder.Code)
+	}
+}
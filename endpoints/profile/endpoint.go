@@ -0,0 +1,319 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	redispubsub "github.com/SomtoJF/iris-api/pkg/redis"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeTokenTTL = 24 * time.Hour
+
+	emailChangeLimit  = 3
+	emailChangeWindow = time.Hour
+)
+
+var errEmailTaken = errors.New("email is already in use")
+
+type Endpoint struct {
+	db          *gorm.DB
+	tokens      *onetimetoken.Store
+	mailer      mailer.Mailer
+	rateLimiter *redispubsub.RedisRateLimiter
+	passwords   *passwordhash.Hasher
+	logger      *log.Logger
+	clientUrl   string
+}
+
+func NewEndpoint(db *gorm.DB, tokens *onetimetoken.Store, mailer mailer.Mailer, rateLimiter *redispubsub.RedisRateLimiter, passwords *passwordhash.Hasher, logger *log.Logger, clientUrl string) *Endpoint {
+	return &Endpoint{db: db, tokens: tokens, mailer: mailer, rateLimiter: rateLimiter, passwords: passwords, logger: logger, clientUrl: clientUrl}
+}
+
+type UpdateProfileRequest struct {
+	FirstName *string `json:"firstName" binding:"omitempty,max=50"`
+	LastName  *string `json:"lastName" binding:"omitempty,max=50"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required,email"`
+	Password string `json:"password" binding:"required,max=256"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// emailChange is stored as the token payload. The swap only happens while
+// the account still has the address the change was requested from.
+type emailChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// UpdateProfile godoc
+//
+//	@Summary		Update profile
+//	@Description	Updates the authenticated user's name. Email changes go through /me/email.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			updateProfileRequest	body		UpdateProfileRequest	true	"Fields to update"
+//	@Success		200						{object}	model.User				"Updated user"
+//	@Failure		400						{object}	map[string]interface{}	"Bad request"
+//	@Failure		401						{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500						{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me [patch]
+func (e *Endpoint) UpdateProfile(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request UpdateProfileRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.FirstName != nil {
+		firstName := strings.TrimSpace(*request.FirstName)
+		if firstName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "First name cannot be empty"})
+			return
+		}
+		updates["first_name"] = firstName
+		user.FirstName = firstName
+	}
+	if request.LastName != nil {
+		lastName := strings.TrimSpace(*request.LastName)
+		if lastName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last name cannot be empty"})
+			return
+		}
+		updates["last_name"] = lastName
+		user.LastName = lastName
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if err := e.db.Model(&model.User{}).Where("id_user = ?", user.IdUser).Updates(updates).Error; err != nil {
+		e.logger.Printf("Failed to update profile: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// ChangeEmail godoc
+//
+//	@Summary		Request an email change
+//	@Description	Sends a confirmation link to the new address. The email is only changed once the link is used.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			changeEmailRequest	body		ChangeEmailRequest		true	"New email and current password"
+//	@Success		200					{object}	map[string]interface{}	"Confirmation sent"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		401					{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		409					{object}	map[string]interface{}	"Email already in use"
+//	@Failure		429					{object}	map[string]interface{}	"Too many requests"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/me/email [post]
+func (e *Endpoint) ChangeEmail(c *gin.Context) {
+	user, ok := c.Value("currentUser").(model.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request ChangeEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.PasswordHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set a password through the forgot password flow before changing your email"})
+		return
+	}
+	if match, _, err := e.passwords.Verify(request.Password, user.PasswordHash); err != nil || !match {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	newEmail := strings.TrimSpace(request.NewEmail)
+	if strings.EqualFold(newEmail, user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New email is the same as the current one"})
+		return
+	}
+
+	taken, err := e.emailTaken(e.db, newEmail)
+	if err != nil {
+		e.logger.Printf("Failed to check email availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		return
+	}
+
+	allowed, retryAfter, err := e.rateLimiter.Allow(c.Request.Context(), "email-change:user", user.IdExternal.String(), emailChangeLimit, emailChangeWindow)
+	if err != nil {
+		e.logger.Printf("Failed to check rate limit: %v", err)
+	} else if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many email change requests, please try again later"})
+		return
+	}
+
+	payload, err := json.Marshal(emailChange{From: user.Email, To: newEmail})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	token, err := e.tokens.Issue(user.IdUser, model.UserTokenPurposeEmailChange, emailChangeTokenTTL, string(payload))
+	if err != nil {
+		e.logger.Printf("Failed to issue email change token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	link := fmt.Sprintf("%s/confirm-email-change?token=%s", strings.TrimSuffix(e.clientUrl, "/"), url.QueryEscape(token))
+	message := mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Iris email address",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to change your Iris email address to this one. Open the link below to confirm:\n\n%s\n\nThe link expires in %d hours. Until then your account keeps using %s.\n",
+			user.FirstName, link, int(emailChangeTokenTTL.Hours()), user.Email),
+	}
+	if err := e.mailer.Send(c.Request.Context(), message); err != nil {
+		e.logger.Printf("Failed to send email change confirmation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Confirmation link sent to " + newEmail})
+}
+
+// ConfirmEmailChange godoc
+//
+//	@Summary		Confirm an email change
+//	@Description	Swaps the account email using the token sent to the new address and notifies the old address
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			confirmEmailChangeRequest	body		ConfirmEmailChangeRequest	true	"Confirmation token"
+//	@Success		200							{object}	map[string]interface{}		"Email changed"
+//	@Failure		400							{object}	map[string]interface{}		"Bad request"
+//	@Failure		409							{object}	map[string]interface{}		"Email already in use"
+//	@Failure		500							{object}	map[string]interface{}		"Internal server error"
+//	@Router			/me/email/confirm [post]
+func (e *Endpoint) ConfirmEmailChange(c *gin.Context) {
+	var request ConfirmEmailChangeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userToken, err := e.tokens.Consume(request.Token, model.UserTokenPurposeEmailChange)
+	if err != nil {
+		if errors.Is(err, onetimetoken.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation link is invalid or has expired"})
+			return
+		}
+		e.logger.Printf("Failed to consume email change token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	var change emailChange
+	if err := json.Unmarshal([]byte(userToken.Payload), &change); err != nil {
+		e.logger.Printf("Malformed email change payload: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation link is invalid or has expired"})
+		return
+	}
+
+	var user model.User
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := e.emailTaken(tx, change.To)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errEmailTaken
+		}
+
+		result := tx.Model(&model.User{}).
+			Where("id_user = ? AND email = ? AND deleted_at IS NULL", userToken.UserId, change.From).
+			Updates(map[string]interface{}{
+				"email":             change.To,
+				"email_verified_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("id_user = ?", userToken.UserId).First(&user).Error
+	})
+	if err != nil {
+		if errors.Is(err, errEmailTaken) || errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation link is invalid or has expired"})
+			return
+		}
+		e.logger.Printf("Failed to change email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	if err := e.notifyEmailChanged(c.Request.Context(), user, change.From); err != nil {
+		e.logger.Printf("Failed to notify previous email address: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully"})
+}
+
+// emailTaken checks every account, including ones pending deletion, since
+// they keep their address until purged
+func (e *Endpoint) emailTaken(db *gorm.DB, email string) (bool, error) {
+	var count int64
+	err := db.Model(&model.User{}).Where("LOWER(email) = ?", strings.ToLower(email)).Count(&count).Error
+	return count > 0, err
+}
+
+func (e *Endpoint) notifyEmailChanged(ctx context.Context, user model.User, previousEmail string) error {
+	return e.mailer.Send(ctx, mailer.Message{
+		To:      previousEmail,
+		Subject: "Your Iris email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address on your Iris account was changed from %s to %s.\n\nIf you did not make this change, reset your password and contact support immediately.\n",
+			user.FirstName, previousEmail, user.Email),
+	})
+}
@@ -5,16 +5,18 @@ import (
 	"time"
 
 	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type Endpoint struct {
-	db *gorm.DB
+	db    *gorm.DB
+	audit *audit.Recorder
 }
 
-func NewEndpoint(db *gorm.DB) *Endpoint {
-	return &Endpoint{db: db}
+func NewEndpoint(db *gorm.DB, auditRecorder *audit.Recorder) *Endpoint {
+	return &Endpoint{db: db, audit: auditRecorder}
 }
 
 type ResumeDTO struct {
@@ -84,6 +86,13 @@ func (e *Endpoint) SetResumeAsActive(c *gin.Context) {
 	if err := tx.Where("id_external = ? AND deleted_at IS NULL AND id_user = ?", id, userId).First(&resume).Error; err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
+			e.audit.Record(c, audit.Event{
+				Action:     model.AuditActionResumeActivate,
+				Outcome:    model.AuditOutcomeFailure,
+				TargetType: "resume",
+				TargetId:   id,
+				Metadata:   map[string]interface{}{"reason": "not_found"},
+			})
 			c.JSON(http.StatusNotFound, gin.H{"error": "Resume not found"})
 			return
 		}
@@ -104,5 +113,12 @@ func (e *Endpoint) SetResumeAsActive(c *gin.Context) {
 		return
 	}
 
+	e.audit.Record(c, audit.Event{
+		Action:     model.AuditActionResumeActivate,
+		Outcome:    model.AuditOutcomeSuccess,
+		TargetType: "resume",
+		TargetId:   resume.IdExternal.String(),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Resume set as active"})
 }
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Endpoint struct {
+	db       *gorm.DB
+	sessions *session.Manager
+	logger   *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, sessions *session.Manager, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, sessions: sessions, logger: logger}
+}
+
+type SessionDTO struct {
+	Id           string     `json:"id"`
+	UserAgent    string     `json:"userAgent"`
+	IpAddress    string     `json:"ipAddress"`
+	Current      bool       `json:"current"`
+	Impersonated bool       `json:"impersonated"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastSeenAt   *time.Time `json:"lastSeenAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+}
+
+// FetchSessions godoc
+//
+//	@Summary		List active sessions
+//	@Description	Lists the devices the user is signed in on, flagging the one making the request
+//	@Tags			sessions
+//	@Produce		json
+//	@Success		200	{object}	[]SessionDTO			"Active sessions"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/sessions [get]
+func (e *Endpoint) FetchSessions(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := e.sessions.ActiveForUser(userId)
+	if err != nil {
+		e.logger.Printf("Failed to fetch sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	currentSessionId := c.GetUint("sessionId")
+	sessionDTOs := make([]SessionDTO, 0, len(sessions))
+	for _, s := range sessions {
+		sessionDTOs = append(sessionDTOs, SessionDTO{
+			Id:           s.IdExternal.String(),
+			UserAgent:    s.UserAgent,
+			IpAddress:    s.IpAddress,
+			Current:      s.IdSession == currentSessionId,
+			Impersonated: s.IsImpersonation(),
+			CreatedAt:    s.CreatedAt,
+			LastSeenAt:   s.LastSeenAt,
+			ExpiresAt:    s.ExpiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessionDTOs})
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Sign out a session
+//	@Description	Revokes one of the user's sessions. Revoking the current session also clears its cookies.
+//	@Tags			sessions
+//	@Param			id	path		string					true	"Session id"
+//	@Success		200	{object}	map[string]interface{}	"Session revoked"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/sessions/{id} [delete]
+func (e *Endpoint) RevokeSession(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var target model.Session
+	if err := e.db.Where("id_external = ? AND id_user = ?", c.Param("id"), userId).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		e.logger.Printf("Failed to find session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find session"})
+		return
+	}
+
+	if err := e.sessions.Revoke(target.IdSession, model.SessionRevokedByUser); err != nil {
+		e.logger.Printf("Failed to revoke session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if target.IdSession == c.GetUint("sessionId") {
+		e.sessions.ClearCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions godoc
+//
+//	@Summary		Sign out everywhere else
+//	@Description	Revokes every session of the user except the one making the request
+//	@Tags			sessions
+//	@Success		200	{object}	map[string]interface{}	"Other sessions revoked"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/sessions/revoke-others [post]
+func (e *Endpoint) RevokeOtherSessions(c *gin.Context) {
+	userId := c.GetUint("userId")
+	sessionId := c.GetUint("sessionId")
+	if userId == 0 || sessionId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := e.sessions.RevokeAllForUser(userId, sessionId, model.SessionRevokedByUser); err != nil {
+		e.logger.Printf("Failed to revoke other sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out other sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out of all other sessions"})
+}
@@ -0,0 +1,212 @@
+package token
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/middleware/verifyauth"
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxActiveTokensPerUser = 20
+
+type Endpoint struct {
+	db     *gorm.DB
+	logger *log.Logger
+}
+
+func NewEndpoint(db *gorm.DB, logger *log.Logger) *Endpoint {
+	return &Endpoint{db: db, logger: logger}
+}
+
+type CreateTokenRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,required"`
+	// Omit for a token that never expires
+	ExpiresInDays *int `json:"expiresInDays" binding:"omitempty,min=1,max=365"`
+}
+
+type TokenDTO struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// FetchTokens godoc
+//
+//	@Summary		List personal access tokens
+//	@Description	Lists the authenticated user's personal access tokens, without their secrets
+//	@Tags			tokens
+//	@Produce		json
+//	@Success		200	{object}	[]TokenDTO				"Tokens"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/tokens [get]
+func (e *Endpoint) FetchTokens(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var tokens []model.PersonalAccessToken
+	if err := e.db.Where("id_user = ?", userId).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		e.logger.Printf("Failed to fetch tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	tokenDTOs := make([]TokenDTO, 0, len(tokens))
+	for _, token := range tokens {
+		tokenDTOs = append(tokenDTOs, toTokenDTO(token))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tokenDTOs})
+}
+
+// CreateToken godoc
+//
+//	@Summary		Create a personal access token
+//	@Description	Creates a scoped token for use in an Authorization: Bearer header. The token is only returned once.
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			createTokenRequest	body		CreateTokenRequest		true	"Token details"
+//	@Success		201					{object}	map[string]interface{}	"Created token"
+//	@Failure		400					{object}	map[string]interface{}	"Bad request"
+//	@Failure		401					{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		500					{object}	map[string]interface{}	"Internal server error"
+//	@Router			/tokens [post]
+func (e *Endpoint) CreateToken(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request CreateTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]string, 0, len(request.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range request.Scopes {
+		if !model.IsValidTokenScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "validScopes": model.AllTokenScopes})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var active int64
+	if err := e.db.Model(&model.PersonalAccessToken{}).Where("id_user = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userId, time.Now()).Count(&active).Error; err != nil {
+		e.logger.Printf("Failed to count tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	if active >= maxActiveTokensPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token limit reached, revoke an existing token first"})
+		return
+	}
+
+	secret, err := securetoken.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	plaintext := verifyauth.PersonalAccessTokenPrefix + secret
+
+	token := model.PersonalAccessToken{
+		UserId:    userId,
+		Name:      strings.TrimSpace(request.Name),
+		Prefix:    plaintext[:len(verifyauth.PersonalAccessTokenPrefix)+4],
+		TokenHash: securetoken.Hash(plaintext),
+		Scopes:    strings.Join(scopes, " "),
+	}
+	if request.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *request.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := e.db.Create(&token).Error; err != nil {
+		e.logger.Printf("Failed to create token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Token created. Copy it now, it will not be shown again",
+		"data": gin.H{
+			"token":   plaintext,
+			"details": toTokenDTO(token),
+		},
+	})
+}
+
+// RevokeToken godoc
+//
+//	@Summary		Revoke a personal access token
+//	@Description	Immediately stops a token from authenticating
+//	@Tags			tokens
+//	@Param			id	path		string					true	"Token id"
+//	@Success		200	{object}	map[string]interface{}	"Token revoked"
+//	@Failure		401	{object}	map[string]interface{}	"Unauthorized"
+//	@Failure		404	{object}	map[string]interface{}	"Not found"
+//	@Failure		500	{object}	map[string]interface{}	"Internal server error"
+//	@Router			/tokens/{id} [delete]
+func (e *Endpoint) RevokeToken(c *gin.Context) {
+	userId := c.GetUint("userId")
+	if userId == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var token model.PersonalAccessToken
+	if err := e.db.Where("id_external = ? AND id_user = ?", c.Param("id"), userId).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find token"})
+		return
+	}
+
+	if token.RevokedAt == nil {
+		if err := e.db.Model(&token).Update("revoked_at", time.Now()).Error; err != nil {
+			e.logger.Printf("Failed to revoke token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+func toTokenDTO(token model.PersonalAccessToken) TokenDTO {
+	return TokenDTO{
+		Id:         token.IdExternal.String(),
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		RevokedAt:  token.RevokedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
@@ -1,6 +1,7 @@
 package sqldb
 
 import (
+	"fmt"
 	"os"
 
 	"gorm.io/driver/sqlite"
@@ -24,9 +25,23 @@ func ConnectToSQLite() error {
 
 	dbPath := dbDir + "/gorm.db"
 
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	// TranslateError turns constraint violations into errors like
+	// gorm.ErrDuplicatedKey that callers can check for
+	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return err
 	}
+	return checkFTS5(DB)
+}
+
+// checkFTS5 makes sure SQLite has the FTS5 module that job search reads
+// job_application_fts with. go-sqlite3 only compiles it in with the
+// sqlite_fts5 build tag, and without it searches would only fail once used.
+func checkFTS5(db *gorm.DB) error {
+	// fts5() only exists when the module is loaded and ignores arguments that
+	// are not an fts5_api pointer
+	if err := db.Exec("SELECT fts5(NULL)").Error; err != nil {
+		return fmt.Errorf("SQLite is built without FTS5, build with -tags sqlite_fts5: %w", err)
+	}
 	return nil
 }
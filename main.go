@@ -1,17 +1,48 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/SomtoJF/iris-api/common"
+	accountendpoint "github.com/SomtoJF/iris-api/endpoints/account"
+	"github.com/SomtoJF/iris-api/endpoints/admin"
+	auditendpoint "github.com/SomtoJF/iris-api/endpoints/audit"
 	"github.com/SomtoJF/iris-api/endpoints/auth"
 	"github.com/SomtoJF/iris-api/endpoints/health"
+	"github.com/SomtoJF/iris-api/endpoints/impersonation"
+	inviteendpoint "github.com/SomtoJF/iris-api/endpoints/invite"
 	"github.com/SomtoJF/iris-api/endpoints/job"
+	"github.com/SomtoJF/iris-api/endpoints/jwks"
+	"github.com/SomtoJF/iris-api/endpoints/legal"
+	"github.com/SomtoJF/iris-api/endpoints/mfa"
+	oidcendpoint "github.com/SomtoJF/iris-api/endpoints/oidc"
+	"github.com/SomtoJF/iris-api/endpoints/passkey"
+	"github.com/SomtoJF/iris-api/endpoints/password"
+	"github.com/SomtoJF/iris-api/endpoints/profile"
 	realtimeeventsse "github.com/SomtoJF/iris-api/endpoints/realtimeeventssse"
 	"github.com/SomtoJF/iris-api/endpoints/resume"
+	sessionendpoint "github.com/SomtoJF/iris-api/endpoints/session"
+	"github.com/SomtoJF/iris-api/endpoints/token"
 	"github.com/SomtoJF/iris-api/initializers/sqldb"
 	"github.com/SomtoJF/iris-api/middleware/verifyauth"
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/accountdata"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/consent"
+	"github.com/SomtoJF/iris-api/pkg/invite"
+	"github.com/SomtoJF/iris-api/pkg/mailer"
+	mfaservice "github.com/SomtoJF/iris-api/pkg/mfa"
+	"github.com/SomtoJF/iris-api/pkg/oidc"
+	"github.com/SomtoJF/iris-api/pkg/onetimetoken"
+	"github.com/SomtoJF/iris-api/pkg/passwordhash"
+	"github.com/SomtoJF/iris-api/pkg/rbac"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"github.com/SomtoJF/iris-api/pkg/signingkeys"
+	"github.com/SomtoJF/iris-api/pkg/webauthn"
 	"github.com/SomtoJF/iris-api/temporal"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -35,13 +66,82 @@ func main() {
 	temporalClient := dependencies.GetTemporalClient()
 	logger := log.Default()
 
+	clientUrl := os.Getenv("CLIENT_URL")
+	if clientUrl == "" {
+		clientUrl = "http://localhost:5173"
+	}
+
+	apiUrl := os.Getenv("API_URL")
+	if apiUrl == "" {
+		apiUrl = "http://localhost:4000"
+	}
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		keysDir = homeDir + "/iris/keys"
+	}
+
+	// Retired keys keep verifying for at least the lifetime of the tokens they signed
+	keyOverlap := time.Hour
+	if overlap, err := time.ParseDuration(os.Getenv("JWT_KEY_OVERLAP")); err == nil {
+		keyOverlap = overlap
+	}
+	keyOverlap = max(keyOverlap, session.AccessTokenTTL)
+
+	signingKeys, err := signingkeys.NewManager(keysDir, keyOverlap, logger)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys, generate one with `make signing-key`: %v", err)
+	}
+
+	passwordConfig, err := passwordhash.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordHasher := passwordhash.New(passwordConfig)
+
+	emailSender, err := mailer.New(mailer.ConfigFromEnv(), logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Grants the admin role to this verified user when no admin exists yet
+	if adminEmail := os.Getenv("BOOTSTRAP_ADMIN_EMAIL"); adminEmail != "" {
+		if admin, err := rbac.BootstrapAdmin(db, adminEmail); err != nil {
+			log.Printf("Skipping admin bootstrap: %v", err)
+		} else {
+			log.Printf("Granted admin role to %s", admin.Email)
+		}
+	}
+
+	// SIGNUP_MODE=invite limits new accounts to holders of an invite code
+	inviteRequired, err := invite.RequiredFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	inviteService := invite.NewService(db, inviteRequired)
+
+	webauthnConfig, err := webauthn.ConfigFromEnv(clientUrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	relyingParty := webauthn.NewRelyingParty(webauthnConfig, webauthn.NewRedisChallengeStore(dependencies.GetRedisClient()))
+
+	identityProviders, err := oidc.ProvidersFromEnv(apiUrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", session.CSRFTokenHeader},
 		AllowCredentials: true,
 	}))
 
@@ -53,36 +153,152 @@ func main() {
 		}
 	}()
 
-	authEndpoint := auth.NewEndpoint(db, os.Getenv("CLIENT_DOMAIN"))
+	sessionManager := session.NewManager(db, signingKeys, os.Getenv("CLIENT_DOMAIN"))
+	userTokens := onetimetoken.NewStore(db)
+	mfaService := mfaservice.NewService(db)
+	auditRecorder := audit.NewRecorder(db, logger)
+	consentService := consent.NewService(db)
+
+	deletionGracePeriod := accountdata.DefaultGracePeriod
+	if days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS")); err == nil && days >= 0 {
+		deletionGracePeriod = time.Duration(days) * 24 * time.Hour
+	}
+	accountDataService := accountdata.NewService(db, temporalClient, sessionManager, logger, deletionGracePeriod, os.Getenv("RESUME_STORAGE_DIR"))
+
+	backgroundCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+	go accountDataService.RunPurger(backgroundCtx, time.Hour)
+	go signingKeys.RunReloader(backgroundCtx, time.Minute)
+
+	accountEndpoint := accountendpoint.NewEndpoint(accountDataService, mfaService, passwordHasher, sessionManager, logger)
+	adminEndpoint := admin.NewEndpoint(db, sessionManager, auditRecorder, logger)
+	auditEndpoint := auditendpoint.NewEndpoint(db, logger)
+	inviteEndpoint := inviteendpoint.NewEndpoint(db, inviteService, emailSender, dependencies.GetRedisRateLimiter(), logger, clientUrl)
+	authEndpoint := auth.NewEndpoint(db, os.Getenv("CLIENT_DOMAIN"), clientUrl, sessionManager, userTokens, emailSender, dependencies.GetRedisRateLimiter(), dependencies.GetRedisLoginGuard(), mfaService, passwordHasher, auditRecorder, inviteService, consentService)
 	healthEndpoint := health.NewEndpoint()
-	jobEndpoint := job.NewEndpoint(db, temporalClient, logger, temporal.JobApplicationTaskQueueName)
+	jwksEndpoint := jwks.NewEndpoint(signingKeys)
+	legalEndpoint := legal.NewEndpoint(db, consentService, auditRecorder, logger)
+	mfaEndpoint := mfa.NewEndpoint(mfaService, passwordHasher, dependencies.GetRedisRateLimiter(), logger)
+	oidcEndpoint := oidcendpoint.NewEndpoint(db, sessionManager, userTokens, identityProviders, oidc.NewStateStore(dependencies.GetRedisClient()), auditRecorder, inviteService, logger, clientUrl)
+	passwordEndpoint := password.NewEndpoint(db, sessionManager, userTokens, emailSender, dependencies.GetRedisRateLimiter(), dependencies.GetRedisLoginGuard(), passwordHasher, auditRecorder, logger, clientUrl)
+	passkeyEndpoint := passkey.NewEndpoint(db, relyingParty, sessionManager, dependencies.GetRedisRateLimiter(), auditRecorder, logger)
+	profileEndpoint := profile.NewEndpoint(db, userTokens, emailSender, dependencies.GetRedisRateLimiter(), passwordHasher, logger, clientUrl)
+	jobEndpoint := job.NewEndpoint(db, temporalClient, dependencies.GetRedisPubSub(), auditRecorder, logger, temporal.JobApplicationTaskQueueName)
+	go jobEndpoint.ResumeBatches(backgroundCtx)
 	realtimeEventsEndpoint := realtimeeventsse.NewEndpoint(dependencies.GetRedisPubSub(), logger)
-	resumeEndpoint := resume.NewEndpoint(db)
+	resumeEndpoint := resume.NewEndpoint(db, auditRecorder)
+	sessionEndpoint := sessionendpoint.NewEndpoint(db, sessionManager, logger)
+	tokenEndpoint := token.NewEndpoint(db, logger)
+	impersonationEndpoint := impersonation.NewEndpoint(db, sessionManager, auditRecorder, logger)
 
-	authMiddleware := verifyauth.NewMiddleware(db)
+	authMiddleware := verifyauth.NewMiddleware(db, sessionManager, auditRecorder, consentService, os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true")
 
 	public := r.Group("/")
 	{
 		public.POST("/login", authEndpoint.Login)
+		public.POST("/login/mfa", authEndpoint.LoginWithMFA)
+		public.POST("/login/magic-link", authEndpoint.RequestMagicLink)
+		public.POST("/login/magic-link/verify", authEndpoint.VerifyMagicLink)
+		public.POST("/login/passkey/options", passkeyEndpoint.LoginOptions)
+		public.POST("/login/passkey", passkeyEndpoint.Login)
 		public.POST("/signup", authEndpoint.Signup)
+		public.POST("/waitlist", inviteEndpoint.JoinWaitlist)
+		public.GET("/legal/documents", legalEndpoint.FetchCurrentDocuments)
+		public.POST("/refresh", authEndpoint.Refresh)
+		public.POST("/password/forgot", passwordEndpoint.ForgotPassword)
+		public.POST("/password/reset", passwordEndpoint.ResetPassword)
+		public.POST("/email/verify", authEndpoint.VerifyEmail)
+		public.POST("/account/unlock", authEndpoint.UnlockAccount)
+		public.POST("/me/email/confirm", profileEndpoint.ConfirmEmailChange)
+		public.GET("/auth/oidc/:provider/login", oidcEndpoint.Login)
+		public.GET("/auth/oidc/:provider/callback", oidcEndpoint.Callback)
 
+		public.GET("/.well-known/jwks.json", jwksEndpoint.GetJWKS)
 		public.GET("/health", healthEndpoint.HealthCheck)
 	}
 
 	protected := r.Group("/")
-	protected.Use(authMiddleware.VerifyAuth())
+	protected.Use(authMiddleware.VerifyAuth(), authMiddleware.RequireCSRFToken(), authMiddleware.BlockImpersonatedWrites())
+	{
+		protected.GET("/me", authMiddleware.RequireScope(model.TokenScopeProfileRead), authEndpoint.GetCurrentUser)
+
+		protected.POST("/jobs/apply", authMiddleware.RequireScope(model.TokenScopeJobsWrite), authMiddleware.RequireVerifiedEmail(), authMiddleware.RequireConsent(), jobEndpoint.ApplyForJob)
+		protected.POST("/jobs/apply/bulk", authMiddleware.RequireScope(model.TokenScopeJobsWrite), authMiddleware.RequireVerifiedEmail(), authMiddleware.RequireConsent(), jobEndpoint.BulkApply)
+		protected.GET("/jobs/batches/:id", authMiddleware.RequireScope(model.TokenScopeJobsRead), authMiddleware.RequireConsent(), jobEndpoint.FetchBatch)
+		protected.GET("/jobs", authMiddleware.RequireScope(model.TokenScopeJobsRead), authMiddleware.RequireConsent(), jobEndpoint.FetchAllJobApplications)
+		protected.GET("/jobs/:id", authMiddleware.RequireScope(model.TokenScopeJobsRead), authMiddleware.RequireConsent(), jobEndpoint.FetchJobApplication)
+		protected.POST("/jobs/:id/cancel", authMiddleware.RequireScope(model.TokenScopeJobsWrite), authMiddleware.RequireConsent(), jobEndpoint.CancelJobApplication)
+		protected.POST("/jobs/:id/retry", authMiddleware.RequireScope(model.TokenScopeJobsWrite), authMiddleware.RequireVerifiedEmail(), authMiddleware.RequireConsent(), jobEndpoint.RetryJobApplication)
+
+		protected.GET("/realtime/events", authMiddleware.RequireScope(model.TokenScopeEventsRead), authMiddleware.RequireConsent(), realtimeEventsEndpoint.StreamEvents)
+
+		protected.GET("/resumes", authMiddleware.RequireScope(model.TokenScopeResumesRead), authMiddleware.RequireConsent(), resumeEndpoint.FetchResumes)
+		protected.PUT("/resumes/:id/activate", authMiddleware.RequireScope(model.TokenScopeResumesWrite), authMiddleware.RequireConsent(), resumeEndpoint.SetResumeAsActive)
+	}
+
+	// Account management needs a signed-in session; personal access tokens are refused
+	account := r.Group("/")
+	account.Use(authMiddleware.VerifyAuth(), authMiddleware.RequireSession(), authMiddleware.RequireCSRFToken(), authMiddleware.BlockImpersonatedWrites())
+	{
+		account.POST("/reset-password", authEndpoint.ResetPassword)
+		account.POST("/email/verify/resend", authEndpoint.ResendVerificationEmail)
+		account.PATCH("/me", profileEndpoint.UpdateProfile)
+		account.POST("/me/email", profileEndpoint.ChangeEmail)
+		account.GET("/me/activity", auditEndpoint.FetchActivity)
+		account.GET("/me/export", accountEndpoint.ExportData)
+		account.DELETE("/me", accountEndpoint.DeleteAccount)
+		account.GET("/me/consents", legalEndpoint.FetchConsents)
+		account.POST("/me/consents", legalEndpoint.AcceptDocuments)
+		account.GET("/me/identities", oidcEndpoint.FetchLinkedIdentities)
+		account.DELETE("/me/identities/:id", oidcEndpoint.UnlinkIdentity)
+
+		account.GET("/mfa", mfaEndpoint.GetStatus)
+		account.POST("/mfa/totp/setup", mfaEndpoint.SetupTotp)
+		account.POST("/mfa/totp/confirm", mfaEndpoint.ConfirmTotp)
+		account.POST("/mfa/totp/disable", mfaEndpoint.DisableTotp)
+		account.POST("/mfa/recovery-codes", mfaEndpoint.RegenerateRecoveryCodes)
+
+		account.GET("/passkeys", passkeyEndpoint.FetchPasskeys)
+		account.POST("/passkeys/register/options", passkeyEndpoint.RegistrationOptions)
+		account.POST("/passkeys/register", passkeyEndpoint.RegisterPasskey)
+		account.DELETE("/passkeys/:id", passkeyEndpoint.DeletePasskey)
+
+		account.GET("/sessions", sessionEndpoint.FetchSessions)
+		account.DELETE("/sessions/:id", sessionEndpoint.RevokeSession)
+		account.POST("/sessions/revoke-others", sessionEndpoint.RevokeOtherSessions)
+
+		account.GET("/tokens", tokenEndpoint.FetchTokens)
+		account.POST("/tokens", tokenEndpoint.CreateToken)
+		account.DELETE("/tokens/:id", tokenEndpoint.RevokeToken)
+	}
+
+	// Ending the current session stays possible while impersonating
+	signOut := r.Group("/")
+	signOut.Use(authMiddleware.VerifyAuth(), authMiddleware.RequireSession(), authMiddleware.RequireCSRFToken())
+	{
+		signOut.POST("/logout", authEndpoint.Logout)
+		signOut.POST("/impersonation/stop", impersonationEndpoint.StopImpersonation)
+	}
+
+	// Operator tooling; personal access tokens are refused even for admins
+	adminGroup := r.Group("/admin")
+	adminGroup.Use(authMiddleware.VerifyAuth(), authMiddleware.RequireSession(), authMiddleware.RequireCSRFToken(), authMiddleware.RequirePermission(model.PermissionUsersRead))
 	{
-		protected.POST("/logout", authEndpoint.Logout)
-		protected.POST("/reset-password", authEndpoint.ResetPassword)
-		protected.GET("/me", authEndpoint.GetCurrentUser)
+		adminGroup.GET("/users", adminEndpoint.FetchUsers)
+		adminGroup.GET("/users/:id", adminEndpoint.FetchUser)
+		adminGroup.PUT("/users/:id/role", authMiddleware.RequirePermission(model.PermissionUsersWrite), adminEndpoint.UpdateUserRole)
+		adminGroup.POST("/users/:id/impersonate", authMiddleware.RequirePermission(model.PermissionUsersImpersonate), impersonationEndpoint.StartImpersonation)
 
-		protected.POST("/jobs/apply", jobEndpoint.ApplyForJob)
-		protected.GET("/jobs", jobEndpoint.FetchAllJobApplications)
+		adminGroup.GET("/audit-events", authMiddleware.RequirePermission(model.PermissionAuditRead), auditEndpoint.FetchAuditEvents)
 
-		protected.GET("/realtime/events", realtimeEventsEndpoint.StreamEvents)
+		adminGroup.GET("/legal-documents", authMiddleware.RequirePermission(model.PermissionLegalWrite), legalEndpoint.FetchDocuments)
+		adminGroup.POST("/legal-documents", authMiddleware.RequirePermission(model.PermissionLegalWrite), legalEndpoint.PublishDocument)
 
-		protected.GET("/resumes", resumeEndpoint.FetchResumes)
-		protected.PUT("/resumes/:id/activate", resumeEndpoint.SetResumeAsActive)
+		adminGroup.GET("/invites", authMiddleware.RequirePermission(model.PermissionInvitesWrite), inviteEndpoint.FetchInvites)
+		adminGroup.POST("/invites", authMiddleware.RequirePermission(model.PermissionInvitesWrite), inviteEndpoint.CreateInvite)
+		adminGroup.DELETE("/invites/:id", authMiddleware.RequirePermission(model.PermissionInvitesWrite), inviteEndpoint.RevokeInvite)
+		adminGroup.GET("/waitlist", authMiddleware.RequirePermission(model.PermissionInvitesWrite), inviteEndpoint.FetchWaitlist)
+		adminGroup.POST("/waitlist/:id/invite", authMiddleware.RequirePermission(model.PermissionInvitesWrite), inviteEndpoint.InviteWaitlistEntry)
 	}
 
 	port := os.Getenv("PORT")
@@ -1,71 +1,378 @@
 package verifyauth
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/audit"
+	"github.com/SomtoJF/iris-api/pkg/consent"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/SomtoJF/iris-api/pkg/session"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"gorm.io/gorm"
 )
 
+// AuthMethod records how the current request was authenticated
+type AuthMethod string
+
+const (
+	AuthMethodSession AuthMethod = "session"
+	AuthMethodToken   AuthMethod = "token"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told
+// apart from other bearer credentials and spotted by secret scanners
+const PersonalAccessTokenPrefix = "iris_pat_"
+
+// Last-used timestamps are only written this often to avoid a write per request
+const lastUsedResolution = time.Minute
+
+var errInvalidPersonalAccessToken = errors.New("invalid personal access token")
+
 type Middleware struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Sessions *session.Manager
+	Audit    *audit.Recorder
+	Consents *consent.Service
+	// When set, routes guarded by RequireVerifiedEmail reject unverified users
+	EmailVerificationRequired bool
 }
 
-func NewMiddleware(db *gorm.DB) *Middleware {
-	return &Middleware{DB: db}
+func NewMiddleware(db *gorm.DB, sessions *session.Manager, auditRecorder *audit.Recorder, consents *consent.Service, emailVerificationRequired bool) *Middleware {
+	return &Middleware{DB: db, Sessions: sessions, Audit: auditRecorder, Consents: consents, EmailVerificationRequired: emailVerificationRequired}
 }
 
+// VerifyAuth authenticates the request with either an Authorization: Bearer
+// personal access token or the Access_Token session cookie. Requests made
+// with an impersonation session are written to the audit log.
 func (m *Middleware) VerifyAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("Access_Token")
+		if bearer, ok := bearerToken(c); ok {
+			m.verifyPersonalAccessToken(c, bearer)
+			return
+		}
+
+		tokenString, err := c.Cookie(session.AccessTokenCookieName)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		currentSession, err := m.Sessions.Authenticate(tokenString)
+		if err != nil {
+			if errors.Is(err, session.ErrInvalidAccessToken) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
 			}
-			return []byte(os.Getenv("SECRET")), nil
-		})
+			if errors.Is(err, session.ErrSessionInactive) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				c.Abort()
+				return
+			}
+			log.Printf("Failed to authenticate session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		var user model.User
+		result := m.DB.Where("id_user = ? AND deleted_at IS NULL", currentSession.UserId).First(&user)
+		if result.Error != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
+		var impersonator *model.User
+		if currentSession.IsImpersonation() {
+			impersonator, err = m.findImpersonator(*currentSession.ImpersonatorId)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation is no longer permitted"})
+				c.Abort()
+				return
+			}
+		}
+
+		if err := m.Sessions.Touch(c, currentSession); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
+		}
+
+		c.Set("currentUser", user)
+		c.Set("userId", user.IdUser)
+		c.Set("sessionId", currentSession.IdSession)
+		c.Set("session", currentSession)
+		c.Set("authMethod", AuthMethodSession)
+		if impersonator != nil {
+			c.Set("impersonator", *impersonator)
+			c.Set("impersonatorId", impersonator.IdUser)
+		}
+
+		c.Next()
+
+		if impersonator != nil {
+			m.recordImpersonatedRequest(c)
+		}
+	}
+}
+
+// findImpersonator loads the admin behind an impersonation session, who must
+// still exist and still be allowed to impersonate
+func (m *Middleware) findImpersonator(impersonatorId uint) (*model.User, error) {
+	var impersonator model.User
+	if err := m.DB.Where("id_user = ? AND deleted_at IS NULL", impersonatorId).First(&impersonator).Error; err != nil {
+		return nil, err
+	}
+	if !impersonator.HasPermission(model.PermissionUsersImpersonate) {
+		return nil, errors.New("impersonator lost the impersonation permission")
+	}
+	return &impersonator, nil
+}
+
+func (m *Middleware) recordImpersonatedRequest(c *gin.Context) {
+	outcome := model.AuditOutcomeSuccess
+	if c.Writer.Status() >= http.StatusBadRequest {
+		outcome = model.AuditOutcomeFailure
+	}
+
+	m.Audit.Record(c, audit.Event{
+		Action:  model.AuditActionImpersonatedRequest,
+		Outcome: outcome,
+		Metadata: map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"route":  c.FullPath(),
+			"status": c.Writer.Status(),
+		},
+	})
+}
+
+func (m *Middleware) verifyPersonalAccessToken(c *gin.Context, bearer string) {
+	token, err := m.findPersonalAccessToken(bearer)
+	if err != nil {
+		if errors.Is(err, errInvalidPersonalAccessToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked token"})
+			c.Abort()
+			return
+		}
+		log.Printf("Failed to authenticate personal access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+		c.Abort()
+		return
+	}
+
+	var user model.User
+	result := m.DB.Where("id_user = ? AND deleted_at IS NULL", token.UserId).First(&user)
+	if result.Error != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedResolution {
+		if err := m.DB.Model(token).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Failed to record token use: %v", err)
+		}
+	}
+
+	c.Set("currentUser", user)
+	c.Set("userId", user.IdUser)
+	c.Set("authMethod", AuthMethodToken)
+	c.Set("tokenScopes", token.ScopeList())
+
+	c.Next()
+}
+
+func (m *Middleware) findPersonalAccessToken(bearer string) (*model.PersonalAccessToken, error) {
+	if !strings.HasPrefix(bearer, PersonalAccessTokenPrefix) {
+		return nil, errInvalidPersonalAccessToken
+	}
+
+	var token model.PersonalAccessToken
+	if err := m.DB.Where("token_hash = ?", securetoken.Hash(bearer)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	if !token.IsActive(time.Now()) {
+		return nil, errInvalidPersonalAccessToken
+	}
+	return &token, nil
+}
+
+// RequireScope restricts personal access tokens to routes covered by their
+// scopes. Session-authenticated requests have full access. Must run after VerifyAuth.
+func (m *Middleware) RequireScope(scope model.TokenScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Value("authMethod") != AuthMethodToken {
+			c.Next()
+			return
+		}
+
+		for _, granted := range c.GetStringSlice("tokenScopes") {
+			if granted == string(scope) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the required scope", "scope": scope})
+		c.Abort()
+	}
+}
+
+// RequireSession rejects requests authenticated with a personal access token.
+// Used for account management routes tokens must never reach. Must run after VerifyAuth.
+func (m *Middleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Value("authMethod") != AuthMethodSession {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires signing in"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireCSRFToken rejects unsafe requests authenticated with the session
+// cookie unless they carry the session's CSRF token in the X-CSRF-Token
+// header. Bearer-token requests cannot be forged by another site and skip the
+// check. Must run after VerifyAuth.
+func (m *Middleware) RequireCSRFToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) || c.Value("authMethod") != AuthMethodSession {
+			c.Next()
+			return
+		}
+
+		currentSession, ok := c.Value("session").(*model.Session)
+		if !ok || !session.VerifyCSRFToken(currentSession, c.GetHeader(session.CSRFTokenHeader)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token", "code": "CSRF_TOKEN_INVALID"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// BlockImpersonatedWrites rejects unsafe requests made with an impersonation
+// session, so support staff can see what a user sees without changing their
+// account or data. Must run after VerifyAuth.
+func (m *Middleware) BlockImpersonatedWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetUint("impersonatorId") != 0 && !isSafeMethod(c.Request.Method) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action is not available while impersonating a user", "code": "IMPERSONATION_READ_ONLY"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission restricts a route to users whose role grants permission.
+// Must run after VerifyAuth.
+func (m *Middleware) RequirePermission(permission model.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := c.Value("currentUser").(model.User)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
 
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if !user.HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action", "permission": permission})
+			c.Abort()
 			return
 		}
 
-		var user model.User
-		result := m.DB.Where("id_external = ?", claims["id"]).First(&user)
-		if result.Error != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks users who have not verified their email address
+// when the email verification policy is enabled. Must run after VerifyAuth.
+func (m *Middleware) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.EmailVerificationRequired {
+			c.Next()
+			return
+		}
+
+		user, ok := c.Value("currentUser").(model.User)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
 
-		c.Set("currentUser", user)
-		c.Set("userId", user.IdUser)
+		if !user.IsEmailVerified() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address to continue", "code": "EMAIL_NOT_VERIFIED"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireConsent blocks users who have not accepted the current version of
+// every published legal document. The response lists the outstanding
+// documents so the client can ask for them to be accepted. Must run after VerifyAuth.
+func (m *Middleware) RequireConsent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.GetUint("userId")
+		if userId == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		outstanding, err := m.Consents.Outstanding(userId)
+		if err != nil {
+			log.Printf("Failed to check consent: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An error occured"})
+			c.Abort()
+			return
+		}
+
+		if len(outstanding) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":     "Please accept the latest terms to continue",
+				"code":      "CONSENT_REQUIRED",
+				"documents": consent.ToDTOs(outstanding),
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
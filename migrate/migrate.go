@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/SomtoJF/iris-api/initializers/sqldb"
 	"github.com/SomtoJF/iris-api/model"
+	"gorm.io/gorm"
 )
 
 func init() {
@@ -17,10 +20,19 @@ func init() {
 func main() {
 	db := sqldb.DB
 
+	// Accounts created before email verification existed are grandfathered in
+	backfillEmailVerification := !db.Migrator().HasColumn(&model.User{}, "EmailVerifiedAt")
+
 	if err := db.AutoMigrate(&model.User{}); err != nil {
 		log.Fatal(err)
 	}
 
+	if backfillEmailVerification {
+		if err := db.Model(&model.User{}).Where("email_verified_at IS NULL").Update("email_verified_at", gorm.Expr("created_at")).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if err := db.AutoMigrate(&model.JobApplication{}); err != nil {
 		log.Fatal(err)
 	}
@@ -28,5 +40,177 @@ func main() {
 	if err := db.AutoMigrate(&model.Resume{}); err != nil {
 		log.Fatal(err)
 	}
+
+	if err := db.AutoMigrate(&model.Session{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.UserToken{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.RecoveryCode{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.LinkedIdentity{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.PersonalAccessToken{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.AccountLockout{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.Invite{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.WaitlistEntry{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.WebAuthnCredential{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.LegalDocument{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.Consent{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.JobApplicationStatusChange{}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Status history is kept by triggers so that changes written by the
+	// workflow worker are recorded alongside those made by the API.
+	statusChangeTriggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS job_application_status_created
+			AFTER INSERT ON job_application
+			BEGIN
+				INSERT INTO job_application_status_change (id_job_application, id_user, to_status, created_at)
+				VALUES (NEW.id_job_application, NEW.id_user, NEW.status, strftime('%Y-%m-%d %H:%M:%f', 'now'));
+			END`,
+		`CREATE TRIGGER IF NOT EXISTS job_application_status_updated
+			AFTER UPDATE OF status ON job_application
+			WHEN OLD.status IS NOT NEW.status
+			BEGIN
+				INSERT INTO job_application_status_change (id_job_application, id_user, from_status, to_status, created_at)
+				VALUES (NEW.id_job_application, NEW.id_user, OLD.status, NEW.status, strftime('%Y-%m-%d %H:%M:%f', 'now'));
+			END`,
+	}
+	for _, trigger := range statusChangeTriggers {
+		if err := db.Exec(trigger).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Applications from before status history existed start with their current status
+	if err := db.Exec(`INSERT INTO job_application_status_change (id_job_application, id_user, to_status, created_at)
+		SELECT id_job_application, id_user, status, updated_at FROM job_application
+		WHERE NOT EXISTS (SELECT 1 FROM job_application_status_change WHERE job_application_status_change.id_job_application = job_application.id_job_application)`).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.JobApplicationAttempt{}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Applications from before retries existed have had exactly one attempt
+	if err := db.Exec(`INSERT INTO job_application_attempt (id_job_application, id_user, number, id_resume, workflow_id, run_id, started_at)
+		SELECT id_job_application, id_user, 1, id_resume, workflow_id, run_id, created_at FROM job_application
+		WHERE NOT EXISTS (SELECT 1 FROM job_application_attempt WHERE job_application_attempt.id_job_application = job_application.id_job_application)`).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.JobApplicationBatch{}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&model.JobApplicationBatchItem{}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Applications from before ATS detection get theirs from the stored URL
+	var undetected []model.JobApplication
+	if err := db.Select("id_job_application", "url").Where("ats_type IS NULL OR ats_type = ''").Find(&undetected).Error; err != nil {
+		log.Fatal(err)
+	}
+	for _, jobApplication := range undetected {
+		if err := db.Model(&model.JobApplication{}).Where("id_job_application = ?", jobApplication.IdJobApplication).Update("ats_type", model.DetectAtsType(jobApplication.Url)).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Full-text index over job applications for search on GET /jobs. It is an
+	// external content table, so triggers keep it in step with job_application.
+	// FTS5 is only compiled into the bundled SQLite with -tags sqlite_fts5.
+	var ftsTables int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'job_application_fts'").Scan(&ftsTables).Error; err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS job_application_fts USING fts5(
+			job_title, company_name, job_description,
+			content = 'job_application', content_rowid = 'id_job_application',
+			tokenize = 'porter unicode61'
+		)`).Error; err != nil {
+		log.Fatalf("Failed to create job_application_fts, is SQLite built with FTS5 (-tags sqlite_fts5)? %v", err)
+	}
+	searchTriggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS job_application_fts_insert
+			AFTER INSERT ON job_application
+			BEGIN
+				INSERT INTO job_application_fts (rowid, job_title, company_name, job_description)
+				VALUES (NEW.id_job_application, NEW.job_title, NEW.company_name, NEW.job_description);
+			END`,
+		`CREATE TRIGGER IF NOT EXISTS job_application_fts_delete
+			AFTER DELETE ON job_application
+			BEGIN
+				INSERT INTO job_application_fts (job_application_fts, rowid, job_title, company_name, job_description)
+				VALUES ('delete', OLD.id_job_application, OLD.job_title, OLD.company_name, OLD.job_description);
+			END`,
+		`CREATE TRIGGER IF NOT EXISTS job_application_fts_update
+			AFTER UPDATE OF job_title, company_name, job_description ON job_application
+			BEGIN
+				INSERT INTO job_application_fts (job_application_fts, rowid, job_title, company_name, job_description)
+				VALUES ('delete', OLD.id_job_application, OLD.job_title, OLD.company_name, OLD.job_description);
+				INSERT INTO job_application_fts (rowid, job_title, company_name, job_description)
+				VALUES (NEW.id_job_application, NEW.job_title, NEW.company_name, NEW.job_description);
+			END`,
+	}
+	for _, trigger := range searchTriggers {
+		if err := db.Exec(trigger).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Index the applications that existed before the index did
+	if ftsTables == 0 {
+		if err := db.Exec("INSERT INTO job_application_fts (job_application_fts) VALUES ('rebuild')").Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := db.AutoMigrate(&model.AuditEvent{}); err != nil {
+		log.Fatal(err)
+	}
+
+	// The audit log is append-only; reject any attempt to rewrite history.
+	for _, operation := range []string{"UPDATE", "DELETE"} {
+		trigger := fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS audit_event_no_%s
+			BEFORE %s ON audit_event
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_event is append-only');
+			END`, strings.ToLower(operation), operation)
+		if err := db.Exec(trigger).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Println("Migration completed")
 }
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+type LockoutScope string
+
+const (
+	LockoutScopeAccount LockoutScope = "account"
+	LockoutScopeIP      LockoutScope = "ip"
+)
+
+// AccountLockout records every lockout triggered by repeated failed logins
+type AccountLockout struct {
+	IdAccountLockout uint         `gorm:"primaryKey;autoIncrement;column:id_account_lockout" json:"_"`
+	Scope            LockoutScope `gorm:"type:varchar(20);not null"`
+	// The email or IP address that was locked out
+	Identifier  string     `gorm:"not null;index"`
+	UserId      *uint      `gorm:"column:id_user;index"`
+	IpAddress   string     `gorm:"type:varchar(64)"`
+	UserAgent   string     `gorm:"type:text"`
+	Failures    int        `gorm:"not null"`
+	LockedUntil time.Time  `gorm:"not null"`
+	UnlockedAt  *time.Time `gorm:"default:NULL"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (AccountLockout) TableName() string {
+	return "account_lockout"
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AtsType is the applicant tracking system hosting a job posting, detected
+// from the posting's URL
+type AtsType string
+
+const (
+	AtsTypeGreenhouse      AtsType = "greenhouse"
+	AtsTypeLever           AtsType = "lever"
+	AtsTypeWorkday         AtsType = "workday"
+	AtsTypeAshby           AtsType = "ashby"
+	AtsTypeSmartRecruiters AtsType = "smartrecruiters"
+	AtsTypeWorkable        AtsType = "workable"
+	AtsTypeBambooHR        AtsType = "bamboohr"
+	AtsTypeICIMS           AtsType = "icims"
+	AtsTypeOther           AtsType = "other"
+)
+
+var AllAtsTypes = []AtsType{
+	AtsTypeGreenhouse,
+	AtsTypeLever,
+	AtsTypeWorkday,
+	AtsTypeAshby,
+	AtsTypeSmartRecruiters,
+	AtsTypeWorkable,
+	AtsTypeBambooHR,
+	AtsTypeICIMS,
+	AtsTypeOther,
+}
+
+// atsDomains maps the domains each ATS serves postings from; subdomains match too
+var atsDomains = map[string]AtsType{
+	"greenhouse.io":       AtsTypeGreenhouse,
+	"lever.co":            AtsTypeLever,
+	"myworkdayjobs.com":   AtsTypeWorkday,
+	"myworkdaysite.com":   AtsTypeWorkday,
+	"ashbyhq.com":         AtsTypeAshby,
+	"smartrecruiters.com": AtsTypeSmartRecruiters,
+	"workable.com":        AtsTypeWorkable,
+	"bamboohr.com":        AtsTypeBambooHR,
+	"icims.com":           AtsTypeICIMS,
+}
+
+// DetectAtsType works out which ATS hosts the posting at rawUrl, or
+// AtsTypeOther when it is none of the known ones
+func DetectAtsType(rawUrl string) AtsType {
+	parsed, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return AtsTypeOther
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for domain, atsType := range atsDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return atsType
+		}
+	}
+	return AtsTypeOther
+}
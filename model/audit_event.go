@@ -0,0 +1,78 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditActionSignup          AuditAction = "auth.signup"
+	AuditActionLogin           AuditAction = "auth.login"
+	AuditActionLoginFailed     AuditAction = "auth.login_failed"
+	AuditActionLoginBlocked    AuditAction = "auth.login_blocked"
+	AuditActionAccountLocked   AuditAction = "auth.account_locked"
+	AuditActionLogout          AuditAction = "auth.logout"
+	AuditActionPasswordChange  AuditAction = "auth.password_change"
+	AuditActionPasswordReset   AuditAction = "auth.password_reset"
+	AuditActionEmailVerified   AuditAction = "auth.email_verified"
+	AuditActionAccountUnlocked AuditAction = "auth.account_unlocked"
+	AuditActionPasskeyAdded    AuditAction = "auth.passkey_added"
+	AuditActionPasskeyRemoved  AuditAction = "auth.passkey_removed"
+	AuditActionConsentAccepted AuditAction = "auth.consent_accepted"
+	AuditActionResumeActivate  AuditAction = "resume.activate"
+	AuditActionJobApply        AuditAction = "job.apply"
+	AuditActionJobCancel       AuditAction = "job.cancel"
+	AuditActionJobRetry        AuditAction = "job.retry"
+	AuditActionJobBulkApply    AuditAction = "job.bulk_apply"
+
+	AuditActionImpersonationStarted AuditAction = "admin.impersonation_started"
+	AuditActionImpersonationEnded   AuditAction = "admin.impersonation_ended"
+	AuditActionImpersonatedRequest  AuditAction = "admin.impersonated_request"
+
+	AuditActionLegalDocumentPublished AuditAction = "admin.legal_document_published"
+
+	AuditActionRoleChanged AuditAction = "admin.role_changed"
+)
+
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// AuditEvent is an append-only record of a security relevant action. UserId
+// is the account the event concerns and ActorId whoever performed it; the
+// actor is unknown for failed logins. Updates and deletes are rejected by
+// database triggers, so events outlive purged accounts.
+type AuditEvent struct {
+	IdAuditEvent uint         `gorm:"primaryKey;autoIncrement;column:id_audit_event" json:"_"`
+	IdExternal   uuid.UUID    `gorm:"type:text;not null;unique" json:"id"`
+	UserId       *uint        `gorm:"column:id_user;index"`
+	ActorId      *uint        `gorm:"column:id_actor;index"`
+	Action       AuditAction  `gorm:"type:varchar(50);not null;index"`
+	Outcome      AuditOutcome `gorm:"type:varchar(20);not null"`
+	TargetType   string       `gorm:"type:varchar(50)"`
+	TargetId     string       `gorm:"type:varchar(100)"`
+	IpAddress    string       `gorm:"type:varchar(64);index"`
+	UserAgent    string       `gorm:"type:text"`
+	// JSON object with action specific details
+	Metadata  string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_event"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (a *AuditEvent) BeforeCreate(tx *gorm.DB) error {
+	if a.IdExternal == uuid.Nil {
+		a.IdExternal = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Consent records that a user accepted a specific legal document version,
+// along with where the acceptance came from
+type Consent struct {
+	IdConsent       uint          `gorm:"primaryKey;autoIncrement;column:id_consent" json:"_"`
+	IdExternal      uuid.UUID     `gorm:"type:text;not null;unique" json:"id"`
+	UserId          uint          `gorm:"column:id_user;not null;uniqueIndex:idx_consent_user_document"`
+	LegalDocumentId uint          `gorm:"column:id_legal_document;not null;uniqueIndex:idx_consent_user_document"`
+	LegalDocument   LegalDocument `gorm:"foreignKey:LegalDocumentId;references:IdLegalDocument"`
+	IpAddress       string        `gorm:"type:varchar(64)"`
+	UserAgent       string        `gorm:"type:text"`
+	AcceptedAt      time.Time     `gorm:"not null"`
+}
+
+func (Consent) TableName() string {
+	return "consent"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (c *Consent) BeforeCreate(tx *gorm.DB) error {
+	if c.IdExternal == uuid.Nil {
+		c.IdExternal = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Invite is an admin-issued signup code that can be redeemed up to MaxUses
+// times before it expires. Users record the invite they redeemed.
+type Invite struct {
+	IdInvite   uint       `gorm:"primaryKey;autoIncrement;column:id_invite" json:"_"`
+	IdExternal uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
+	Code       string     `gorm:"type:varchar(32);not null;uniqueIndex"`
+	Note       string     `gorm:"type:varchar(255)"`
+	Email      string     `gorm:"type:varchar(255)"`
+	MaxUses    int        `gorm:"not null;default:1"`
+	Uses       int        `gorm:"not null;default:0"`
+	CreatorId  *uint      `gorm:"column:id_creator;default:NULL"`
+	ExpiresAt  *time.Time `gorm:"default:NULL"`
+	RevokedAt  *time.Time `gorm:"default:NULL"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (Invite) TableName() string {
+	return "invite"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (i *Invite) BeforeCreate(tx *gorm.DB) error {
+	if i.IdExternal == uuid.Nil {
+		i.IdExternal = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// JobApplicationAttempt is one workflow run of a job application. The first
+// attempt is made on apply and each retry adds another. Outcome and
+// FailureReason are filled in when a later attempt replaces this one; until
+// then the application itself holds the latest attempt's state.
+type JobApplicationAttempt struct {
+	IdJobApplicationAttempt uint                 `gorm:"primaryKey;autoIncrement;column:id_job_application_attempt" json:"_"`
+	JobApplicationId        uint                 `gorm:"column:id_job_application;not null;uniqueIndex:idx_job_application_attempt_number"`
+	UserId                  uint                 `gorm:"column:id_user;not null;index"`
+	Number                  int                  `gorm:"not null;uniqueIndex:idx_job_application_attempt_number"`
+	ResumeId                *uint                `gorm:"column:id_resume;default:NULL"`
+	WorkflowId              string               `gorm:"default:NULL"`
+	RunId                   string               `gorm:"default:NULL"`
+	Outcome                 JobApplicationStatus `gorm:"type:varchar(50);default:NULL"`
+	FailureReason           string               `gorm:"type:text;default:NULL"`
+	StartedAt               time.Time            `gorm:"not null"`
+	FinishedAt              *time.Time           `gorm:"default:NULL"`
+}
+
+func (JobApplicationAttempt) TableName() string {
+	return "job_application_attempt"
+}
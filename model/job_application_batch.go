@@ -0,0 +1,62 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type JobApplicationBatchItemStatus string
+
+const (
+	JobApplicationBatchItemQueued    JobApplicationBatchItemStatus = "queued"
+	JobApplicationBatchItemStarted   JobApplicationBatchItemStatus = "started"
+	JobApplicationBatchItemFailed    JobApplicationBatchItemStatus = "failed"
+	JobApplicationBatchItemInvalid   JobApplicationBatchItemStatus = "invalid"
+	JobApplicationBatchItemDuplicate JobApplicationBatchItemStatus = "duplicate"
+	JobApplicationBatchItemCancelled JobApplicationBatchItemStatus = "cancelled"
+)
+
+// JobApplicationBatch groups the applications submitted together through bulk
+// apply. CompletedAt is set once every queued item has been started, has failed
+// or was cancelled before it started.
+type JobApplicationBatch struct {
+	IdJobApplicationBatch uint                      `gorm:"primaryKey;autoIncrement;column:id_job_application_batch" json:"_"`
+	IdExternal            uuid.UUID                 `gorm:"type:text;not null;unique" json:"id"`
+	UserId                uint                      `gorm:"column:id_user;not null;index"`
+	Items                 []JobApplicationBatchItem `gorm:"foreignKey:BatchId;references:IdJobApplicationBatch"`
+	CreatedAt             time.Time                 `gorm:"default:CURRENT_TIMESTAMP"`
+	CompletedAt           *time.Time                `gorm:"default:NULL"`
+}
+
+func (JobApplicationBatch) TableName() string {
+	return "job_application_batch"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (b *JobApplicationBatch) BeforeCreate(tx *gorm.DB) error {
+	if b.IdExternal == uuid.Nil {
+		b.IdExternal = uuid.New()
+	}
+	return nil
+}
+
+// JobApplicationBatchItem is one submitted URL of a batch, in the order it
+// was submitted
+type JobApplicationBatchItem struct {
+	IdJobApplicationBatchItem uint                          `gorm:"primaryKey;autoIncrement;column:id_job_application_batch_item" json:"_"`
+	BatchId                   uint                          `gorm:"column:id_job_application_batch;not null;index"`
+	UserId                    uint                          `gorm:"column:id_user;not null;index"`
+	Position                  int                           `gorm:"not null"`
+	Url                       string                        `gorm:"type:text;not null"`
+	Status                    JobApplicationBatchItemStatus `gorm:"type:varchar(20);not null"`
+	Error                     string                        `gorm:"type:text;default:NULL"`
+	JobApplicationId          *uint                         `gorm:"column:id_job_application;default:NULL"`
+	JobApplication            *JobApplication               `gorm:"foreignKey:JobApplicationId;references:IdJobApplication"`
+	UpdatedAt                 time.Time                     `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (JobApplicationBatchItem) TableName() string {
+	return "job_application_batch_item"
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// JobApplicationStatusChange is one entry in a job application's status
+// history. Rows are written by database triggers on job_application, so
+// changes made by the workflow worker are captured as well. FromStatus is
+// empty for the status an application was created with.
+type JobApplicationStatusChange struct {
+	IdJobApplicationStatusChange uint                 `gorm:"primaryKey;autoIncrement;column:id_job_application_status_change" json:"_"`
+	JobApplicationId             uint                 `gorm:"column:id_job_application;not null;index"`
+	UserId                       uint                 `gorm:"column:id_user;not null;index"`
+	FromStatus                   JobApplicationStatus `gorm:"type:varchar(50);default:NULL"`
+	ToStatus                     JobApplicationStatus `gorm:"type:varchar(50);not null"`
+	CreatedAt                    time.Time            `gorm:"not null"`
+}
+
+func (JobApplicationStatusChange) TableName() string {
+	return "job_application_status_change"
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type LegalDocumentKind string
+
+const (
+	LegalDocumentTerms   LegalDocumentKind = "terms"
+	LegalDocumentPrivacy LegalDocumentKind = "privacy"
+)
+
+var AllLegalDocumentKinds = []LegalDocumentKind{LegalDocumentTerms, LegalDocumentPrivacy}
+
+// IsValidLegalDocumentKind reports whether kind is one of AllLegalDocumentKinds
+func IsValidLegalDocumentKind(kind string) bool {
+	for _, k := range AllLegalDocumentKinds {
+		if string(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// LegalDocument is one published version of the terms of service or privacy
+// policy. The latest version of each kind whose EffectiveAt has passed is the
+// one users must accept.
+type LegalDocument struct {
+	IdLegalDocument uint              `gorm:"primaryKey;autoIncrement;column:id_legal_document" json:"_"`
+	IdExternal      uuid.UUID         `gorm:"type:text;not null;unique" json:"id"`
+	Kind            LegalDocumentKind `gorm:"type:varchar(20);not null;uniqueIndex:idx_legal_document_kind_version"`
+	Version         string            `gorm:"type:varchar(50);not null;uniqueIndex:idx_legal_document_kind_version"`
+	Title           string            `gorm:"type:varchar(255);not null"`
+	Url             string            `gorm:"type:text;not null"`
+	EffectiveAt     time.Time         `gorm:"not null;index"`
+	CreatedAt       time.Time         `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt       time.Time         `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (LegalDocument) TableName() string {
+	return "legal_document"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (d *LegalDocument) BeforeCreate(tx *gorm.DB) error {
+	if d.IdExternal == uuid.Nil {
+		d.IdExternal = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LinkedIdentity connects a user to an account at an external identity
+// provider such as Google or GitHub
+type LinkedIdentity struct {
+	IdLinkedIdentity uint       `gorm:"primaryKey;autoIncrement;column:id_linked_identity" json:"_"`
+	IdExternal       uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
+	UserId           uint       `gorm:"column:id_user;not null;index"`
+	User             User       `gorm:"foreignKey:UserId;references:IdUser"`
+	Provider         string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_linked_identity_provider_subject"`
+	Subject          string     `gorm:"not null;uniqueIndex:idx_linked_identity_provider_subject"`
+	Email            string     `gorm:"not null"`
+	LastUsedAt       *time.Time `gorm:"default:NULL"`
+	CreatedAt        time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt        time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (LinkedIdentity) TableName() string {
+	return "linked_identity"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (l *LinkedIdentity) BeforeCreate(tx *gorm.DB) error {
+	if l.IdExternal == uuid.Nil {
+		l.IdExternal = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TokenScope string
+
+const (
+	TokenScopeProfileRead  TokenScope = "profile:read"
+	TokenScopeJobsRead     TokenScope = "jobs:read"
+	TokenScopeJobsWrite    TokenScope = "jobs:write"
+	TokenScopeResumesRead  TokenScope = "resumes:read"
+	TokenScopeResumesWrite TokenScope = "resumes:write"
+	TokenScopeEventsRead   TokenScope = "events:read"
+)
+
+var AllTokenScopes = []TokenScope{
+	TokenScopeProfileRead,
+	TokenScopeJobsRead,
+	TokenScopeJobsWrite,
+	TokenScopeResumesRead,
+	TokenScopeResumesWrite,
+	TokenScopeEventsRead,
+}
+
+// IsValidTokenScope reports whether scope is one of AllTokenScopes
+func IsValidTokenScope(scope string) bool {
+	for _, s := range AllTokenScopes {
+		if string(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PersonalAccessToken lets scripts and integrations call the API with an
+// Authorization: Bearer header. Only the hash of the token is stored.
+type PersonalAccessToken struct {
+	IdPersonalAccessToken uint      `gorm:"primaryKey;autoIncrement;column:id_personal_access_token" json:"_"`
+	IdExternal            uuid.UUID `gorm:"type:text;not null;unique" json:"id"`
+	UserId                uint      `gorm:"column:id_user;not null;index"`
+	User                  User      `gorm:"foreignKey:UserId;references:IdUser"`
+	Name                  string    `gorm:"type:varchar(100);not null"`
+	// First characters of the token, shown so users can tell tokens apart
+	Prefix     string     `gorm:"type:varchar(20);not null"`
+	TokenHash  string     `gorm:"not null;uniqueIndex"`
+	Scopes     string     `gorm:"type:text;not null"`
+	ExpiresAt  *time.Time `gorm:"default:NULL"`
+	LastUsedAt *time.Time `gorm:"default:NULL"`
+	RevokedAt  *time.Time `gorm:"default:NULL"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (PersonalAccessToken) TableName() string {
+	return "personal_access_token"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (p *PersonalAccessToken) BeforeCreate(tx *gorm.DB) error {
+	if p.IdExternal == uuid.Nil {
+		p.IdExternal = uuid.New()
+	}
+	return nil
+}
+
+// ScopeList returns the scopes granted to the token
+func (p *PersonalAccessToken) ScopeList() []string {
+	return strings.Fields(p.Scopes)
+}
+
+// IsActive reports whether the token can still be used to authenticate
+func (p *PersonalAccessToken) IsActive(now time.Time) bool {
+	return p.RevokedAt == nil && (p.ExpiresAt == nil || now.Before(*p.ExpiresAt))
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// RecoveryCode is a single-use backup code for accounts with two-factor
+// authentication. Only the hash of the code is stored.
+type RecoveryCode struct {
+	IdRecoveryCode uint       `gorm:"primaryKey;autoIncrement;column:id_recovery_code" json:"_"`
+	UserId         uint       `gorm:"column:id_user;not null;index"`
+	User           User       `gorm:"foreignKey:UserId;references:IdUser"`
+	CodeHash       string     `gorm:"not null;uniqueIndex"`
+	UsedAt         *time.Time `gorm:"default:NULL"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_code"
+}
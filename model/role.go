@@ -0,0 +1,58 @@
+package model
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+var AllRoles = []Role{RoleUser, RoleAdmin}
+
+// IsValidRole reports whether role is one of AllRoles
+func IsValidRole(role string) bool {
+	for _, r := range AllRoles {
+		if string(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Permission string
+
+const (
+	PermissionUsersRead        Permission = "users:read"
+	PermissionUsersWrite       Permission = "users:write"
+	PermissionAuditRead        Permission = "audit:read"
+	PermissionInvitesWrite     Permission = "invites:write"
+	PermissionUsersImpersonate Permission = "users:impersonate"
+	PermissionLegalWrite       Permission = "legal:write"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {},
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionAuditRead,
+		PermissionInvitesWrite,
+		PermissionUsersImpersonate,
+		PermissionLegalWrite,
+	},
+}
+
+// Permissions returns the permissions granted to the role
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Can reports whether the role grants permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SessionRevocationReason string
+
+const (
+	SessionRevokedLogout         SessionRevocationReason = "logout"
+	SessionRevokedPasswordChange SessionRevocationReason = "password_change"
+	SessionRevokedTokenReuse     SessionRevocationReason = "refresh_token_reuse"
+	SessionRevokedAccountDeleted SessionRevocationReason = "account_deleted"
+	SessionRevokedByUser         SessionRevocationReason = "revoked_by_user"
+	SessionRevokedImpersonation  SessionRevocationReason = "impersonation_ended"
+	SessionRevokedAccountClaimed SessionRevocationReason = "account_claimed"
+	SessionRevokedRoleChange     SessionRevocationReason = "role_changed"
+)
+
+// Session is a server-side login session. The refresh token presented by the
+// client is rotated on every use and only its hash is kept here, as is the
+// hash of the CSRF token that cookie-authenticated requests must echo back.
+// Impersonation sessions belong to the impersonated user and record the admin
+// acting as them in ImpersonatorId.
+type Session struct {
+	IdSession           uint                    `gorm:"primaryKey;autoIncrement;column:id_session" json:"_"`
+	IdExternal          uuid.UUID               `gorm:"type:text;not null;unique" json:"id"`
+	UserId              uint                    `gorm:"column:id_user;not null;index"`
+	User                User                    `gorm:"foreignKey:UserId;references:IdUser"`
+	RefreshTokenHash    string                  `gorm:"not null;uniqueIndex"`
+	CsrfTokenHash       string                  `gorm:"type:varchar(64)"`
+	ExpiresAt           time.Time               `gorm:"not null"`
+	RevokedAt           *time.Time              `gorm:"index;default:NULL"`
+	RevokedReason       SessionRevocationReason `gorm:"type:varchar(50)"`
+	UserAgent           string                  `gorm:"type:text"`
+	IpAddress           string                  `gorm:"type:varchar(64)"`
+	LastSeenAt          *time.Time              `gorm:"default:NULL"`
+	ImpersonatorId      *uint                   `gorm:"column:id_impersonator;index;default:NULL"`
+	Impersonator        *User                   `gorm:"foreignKey:ImpersonatorId;references:IdUser"`
+	ImpersonationReason string                  `gorm:"type:varchar(500)"`
+	CreatedAt           time.Time               `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt           time.Time               `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (Session) TableName() string {
+	return "session"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.IdExternal == uuid.Nil {
+		s.IdExternal = uuid.New()
+	}
+	return nil
+}
+
+// IsImpersonation reports whether an admin is acting as the user in this session
+func (s *Session) IsImpersonation() bool {
+	return s.ImpersonatorId != nil
+}
+
+// IsActive reports whether the session can still be used to authenticate
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -8,24 +8,49 @@ import (
 )
 
 type User struct {
-	IdUser       uint       `gorm:"primaryKey;autoIncrement;column:id_user" json:"_"`
-	IdExternal   uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
-	FirstName    string     `gorm:"not null"`
-	LastName     string     `gorm:"not null"`
-	Email        string     `gorm:"uniqueIndex;not null"`
-	PasswordHash string     `gorm:"not null"`
-	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
-	UpdatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
-	DeletedAt    *time.Time `gorm:"index;default:NULL"`
+	IdUser          uint       `gorm:"primaryKey;autoIncrement;column:id_user" json:"_"`
+	IdExternal      uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
+	FirstName       string     `gorm:"not null"`
+	LastName        string     `gorm:"not null"`
+	Email           string     `gorm:"uniqueIndex;not null"`
+	PasswordHash    string     `gorm:"not null" json:"-"`
+	EmailVerifiedAt *time.Time `gorm:"default:NULL"`
+	Role            Role       `gorm:"not null;default:user;index"`
+	InviteId        *uint      `gorm:"column:id_invite;index;default:NULL" json:"-"`
+	// TOTP secret, set during enrollment and only trusted once TotpEnabledAt is set
+	TotpSecret       string     `json:"-"`
+	TotpEnabledAt    *time.Time `gorm:"default:NULL"`
+	TotpLastUsedStep int64      `gorm:"default:0" json:"-"`
+	CreatedAt        time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt        time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+	DeletedAt        *time.Time `gorm:"index;default:NULL"`
 }
 
 func (User) TableName() string {
 	return "user"
 }
 
+// IsEmailVerified reports whether the user has confirmed ownership of Email
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// IsTotpEnabled reports whether login requires a second factor
+func (u *User) IsTotpEnabled() bool {
+	return u.TotpEnabledAt != nil
+}
+
+// HasPermission reports whether the user's role grants permission
+func (u *User) HasPermission(permission Permission) bool {
+	return u.Role.Can(permission)
+}
+
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.IdExternal == uuid.Nil {
 		u.IdExternal = uuid.New()
 	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 	return nil
 }
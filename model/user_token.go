@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+)
+
+type UserTokenPurpose string
+
+const (
+	UserTokenPurposePasswordReset     UserTokenPurpose = "password_reset"
+	UserTokenPurposeEmailVerification UserTokenPurpose = "email_verification"
+	UserTokenPurposeLoginChallenge    UserTokenPurpose = "login_challenge"
+	UserTokenPurposeAccountUnlock     UserTokenPurpose = "account_unlock"
+	UserTokenPurposeEmailChange       UserTokenPurpose = "email_change"
+	UserTokenPurposeMagicLink         UserTokenPurpose = "magic_link"
+)
+
+// UserToken is a single-use, expiring secret sent to a user out of band.
+// Only the hash of the token is stored.
+type UserToken struct {
+	IdUserToken uint             `gorm:"primaryKey;autoIncrement;column:id_user_token" json:"_"`
+	UserId      uint             `gorm:"column:id_user;not null;index"`
+	User        User             `gorm:"foreignKey:UserId;references:IdUser"`
+	Purpose     UserTokenPurpose `gorm:"type:varchar(50);not null;index"`
+	TokenHash   string           `gorm:"not null;uniqueIndex"`
+	// Optional purpose specific data
+	Payload    string     `gorm:"type:text"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time `gorm:"default:NULL"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (UserToken) TableName() string {
+	return "user_token"
+}
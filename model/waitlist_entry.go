@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WaitlistEntry is someone who asked for access while signup is invite-only
+type WaitlistEntry struct {
+	IdWaitlistEntry uint       `gorm:"primaryKey;autoIncrement;column:id_waitlist_entry" json:"_"`
+	IdExternal      uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
+	Email           string     `gorm:"type:varchar(255);not null;uniqueIndex"`
+	Note            string     `gorm:"type:varchar(500)"`
+	InviteId        *uint      `gorm:"column:id_invite;default:NULL"`
+	InvitedAt       *time.Time `gorm:"default:NULL"`
+	SignedUpAt      *time.Time `gorm:"default:NULL"`
+	CreatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (WaitlistEntry) TableName() string {
+	return "waitlist_entry"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (w *WaitlistEntry) BeforeCreate(tx *gorm.DB) error {
+	if w.IdExternal == uuid.Nil {
+		w.IdExternal = uuid.New()
+	}
+	return nil
+}
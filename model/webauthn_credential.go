@@ -0,0 +1,49 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredential is a passkey registered by a user. PublicKey holds the
+// COSE encoded key reported by the authenticator at registration.
+type WebAuthnCredential struct {
+	IdWebAuthnCredential uint       `gorm:"primaryKey;autoIncrement;column:id_webauthn_credential" json:"_"`
+	IdExternal           uuid.UUID  `gorm:"type:text;not null;unique" json:"id"`
+	UserId               uint       `gorm:"column:id_user;not null;index"`
+	User                 User       `gorm:"foreignKey:UserId;references:IdUser"`
+	CredentialId         string     `gorm:"not null;uniqueIndex"`
+	PublicKey            []byte     `gorm:"not null"`
+	SignCount            uint32     `gorm:"not null;default:0"`
+	Aaguid               string     `gorm:"type:varchar(36)"`
+	Transports           string     `gorm:"type:varchar(255)"`
+	BackupEligible       bool       `gorm:"not null;default:false"`
+	BackedUp             bool       `gorm:"not null;default:false"`
+	Name                 string     `gorm:"type:varchar(100);not null"`
+	LastUsedAt           *time.Time `gorm:"default:NULL"`
+	CreatedAt            time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt            time.Time  `gorm:"default:CURRENT_TIMESTAMP;autoUpdateTime"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credential"
+}
+
+// BeforeCreate hook to auto-generate UUID
+func (w *WebAuthnCredential) BeforeCreate(tx *gorm.DB) error {
+	if w.IdExternal == uuid.Nil {
+		w.IdExternal = uuid.New()
+	}
+	return nil
+}
+
+// TransportList returns the transports the authenticator reported, if any
+func (w *WebAuthnCredential) TransportList() []string {
+	if w.Transports == "" {
+		return nil
+	}
+	return strings.Split(w.Transports, ",")
+}
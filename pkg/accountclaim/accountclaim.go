@@ -0,0 +1,55 @@
+package accountclaim
+
+import (
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"gorm.io/gorm"
+)
+
+// Claim marks the user's email as verified for someone who just proved they
+// own it, such as through a social login or a magic link. Anyone can sign up
+// with an address they do not own, so when the email was not verified yet the
+// password, second factor, passkeys, access tokens and pending emailed links
+// set up before then are removed too. It reports whether the account was
+// claimed, in which case the caller must also revoke the user's sessions once
+// tx has committed.
+func Claim(tx *gorm.DB, user *model.User, now time.Time) (bool, error) {
+	result := tx.Model(&model.User{}).
+		Where("id_user = ? AND email_verified_at IS NULL", user.IdUser).
+		Updates(map[string]interface{}{
+			"email_verified_at":   now,
+			"password_hash":       "",
+			"totp_secret":         "",
+			"totp_enabled_at":     nil,
+			"totp_last_used_step": 0,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if err := tx.Where("id_user = ?", user.IdUser).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("id_user = ?", user.IdUser).Delete(&model.WebAuthnCredential{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Model(&model.PersonalAccessToken{}).Where("id_user = ? AND revoked_at IS NULL", user.IdUser).Update("revoked_at", now).Error; err != nil {
+		return false, err
+	}
+	// A pending email change would otherwise let the first registrant move the
+	// account to an address they control
+	if err := tx.Model(&model.UserToken{}).Where("id_user = ? AND consumed_at IS NULL", user.IdUser).Update("consumed_at", now).Error; err != nil {
+		return false, err
+	}
+
+	user.EmailVerifiedAt = &now
+	user.PasswordHash = ""
+	user.TotpSecret = ""
+	user.TotpEnabledAt = nil
+	user.TotpLastUsedStep = 0
+	return true, nil
+}
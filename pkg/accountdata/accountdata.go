@@ -0,0 +1,411 @@
+package accountdata
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/session"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"gorm.io/gorm"
+)
+
+// DefaultGracePeriod is how long a deleted account is kept before it is purged
+const DefaultGracePeriod = 30 * 24 * time.Hour
+
+var ErrAlreadyDeleted = errors.New("account is already scheduled for deletion")
+
+// Service exports a user's personal data and deletes accounts. Deletion is
+// two-phase: the account is soft-deleted immediately and purged for good
+// once the grace period has passed.
+type Service struct {
+	db             *gorm.DB
+	temporalClient client.Client
+	sessions       *session.Manager
+	logger         *log.Logger
+	gracePeriod    time.Duration
+	// Directory resume files are stored under, empty when they are not kept on disk
+	resumeDir string
+}
+
+func NewService(db *gorm.DB, temporalClient client.Client, sessions *session.Manager, logger *log.Logger, gracePeriod time.Duration, resumeDir string) *Service {
+	return &Service{db: db, temporalClient: temporalClient, sessions: sessions, logger: logger, gracePeriod: gracePeriod, resumeDir: resumeDir}
+}
+
+// GracePeriod returns how long deleted accounts are kept before being purged
+func (s *Service) GracePeriod() time.Duration {
+	return s.gracePeriod
+}
+
+type exportedUser struct {
+	Id              string     `json:"id"`
+	FirstName       string     `json:"firstName"`
+	LastName        string     `json:"lastName"`
+	Email           string     `json:"email"`
+	Role            model.Role `json:"role"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	TotpEnabled     bool       `json:"totpEnabled"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// exportedResume.ArchivePath locates the original file inside the archive and
+// is empty when the file is not stored locally
+type exportedResume struct {
+	Id           string     `json:"id"`
+	FileName     string     `json:"fileName"`
+	FileSize     int64      `json:"fileSize"`
+	Url          string     `json:"url"`
+	Content      string     `json:"content"`
+	Summary      string     `json:"summary"`
+	IsActive     bool       `json:"isActive"`
+	IsProcessing bool       `json:"isProcessing"`
+	ArchivePath  string     `json:"archivePath"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+	DeletedAt    *time.Time `json:"deletedAt"`
+}
+
+type exportedJobApplication struct {
+	Id             string                     `json:"id"`
+	Url            string                     `json:"url"`
+	Status         model.JobApplicationStatus `json:"status"`
+	JobTitle       string                     `json:"jobTitle"`
+	CompanyName    string                     `json:"companyName"`
+	JobDescription string                     `json:"jobDescription"`
+	CreatedAt      time.Time                  `json:"createdAt"`
+	UpdatedAt      time.Time                  `json:"updatedAt"`
+	DeletedAt      *time.Time                 `json:"deletedAt"`
+}
+
+type exportedConsent struct {
+	Id          string                  `json:"id"`
+	Kind        model.LegalDocumentKind `json:"kind"`
+	Version     string                  `json:"version"`
+	DocumentUrl string                  `json:"documentUrl"`
+	IpAddress   string                  `json:"ipAddress"`
+	UserAgent   string                  `json:"userAgent"`
+	AcceptedAt  time.Time               `json:"acceptedAt"`
+}
+
+// Export writes a zip archive of everything held about the user: their
+// profile, resumes, job applications and consents as JSON, and the original
+// resume files.
+func (s *Service) Export(ctx context.Context, user model.User, w io.Writer) error {
+	var resumes []model.Resume
+	if err := s.db.WithContext(ctx).Where("id_user = ?", user.IdUser).Order("created_at ASC").Find(&resumes).Error; err != nil {
+		return fmt.Errorf("failed to load resumes: %w", err)
+	}
+
+	var jobApplications []model.JobApplication
+	if err := s.db.WithContext(ctx).Where("id_user = ?", user.IdUser).Order("created_at ASC").Find(&jobApplications).Error; err != nil {
+		return fmt.Errorf("failed to load job applications: %w", err)
+	}
+
+	var consents []model.Consent
+	if err := s.db.WithContext(ctx).Preload("LegalDocument").Where("id_user = ?", user.IdUser).Order("accepted_at ASC").Find(&consents).Error; err != nil {
+		return fmt.Errorf("failed to load consents: %w", err)
+	}
+
+	archive := zip.NewWriter(w)
+
+	exportedResumes := make([]exportedResume, 0, len(resumes))
+	for _, resume := range resumes {
+		exported := exportedResume{
+			Id:           resume.IdExternal.String(),
+			FileName:     resume.FileName,
+			FileSize:     resume.FileSize,
+			Url:          resume.Url,
+			Content:      resume.Content,
+			Summary:      resume.Summary,
+			IsActive:     resume.IsActive,
+			IsProcessing: resume.IsProcessing,
+			CreatedAt:    resume.CreatedAt,
+			UpdatedAt:    resume.UpdatedAt,
+			DeletedAt:    resume.DeletedAt,
+		}
+
+		if path, ok := s.localResumePath(resume); ok {
+			archivePath := fmt.Sprintf("resumes/%s-%s", resume.IdExternal, filepath.Base(resume.FileName))
+			if err := addFile(archive, archivePath, path); err != nil {
+				s.logger.Printf("Failed to add resume %s to export: %v", resume.IdExternal, err)
+			} else {
+				exported.ArchivePath = archivePath
+			}
+		}
+		exportedResumes = append(exportedResumes, exported)
+	}
+
+	exportedJobApplications := make([]exportedJobApplication, 0, len(jobApplications))
+	for _, jobApplication := range jobApplications {
+		exportedJobApplications = append(exportedJobApplications, exportedJobApplication{
+			Id:             jobApplication.IdExternal.String(),
+			Url:            jobApplication.Url,
+			Status:         jobApplication.Status,
+			JobTitle:       jobApplication.JobTitle,
+			CompanyName:    jobApplication.CompanyName,
+			JobDescription: jobApplication.JobDescription,
+			CreatedAt:      jobApplication.CreatedAt,
+			UpdatedAt:      jobApplication.UpdatedAt,
+			DeletedAt:      jobApplication.DeletedAt,
+		})
+	}
+
+	exportedConsents := make([]exportedConsent, 0, len(consents))
+	for _, consent := range consents {
+		exportedConsents = append(exportedConsents, exportedConsent{
+			Id:          consent.IdExternal.String(),
+			Kind:        consent.LegalDocument.Kind,
+			Version:     consent.LegalDocument.Version,
+			DocumentUrl: consent.LegalDocument.Url,
+			IpAddress:   consent.IpAddress,
+			UserAgent:   consent.UserAgent,
+			AcceptedAt:  consent.AcceptedAt,
+		})
+	}
+
+	documents := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", exportedUser{
+			Id:              user.IdExternal.String(),
+			FirstName:       user.FirstName,
+			LastName:        user.LastName,
+			Email:           user.Email,
+			Role:            user.Role,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+			TotpEnabled:     user.IsTotpEnabled(),
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+		}},
+		{"resumes.json", exportedResumes},
+		{"job_applications.json", exportedJobApplications},
+		{"consents.json", exportedConsents},
+	}
+	for _, document := range documents {
+		if err := addJSON(archive, document.name, document.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// ScheduleDeletion soft-deletes the account and its data, stops in-flight job
+// applications and signs the user out everywhere. The data is purged by
+// PurgeExpired once the grace period has passed.
+func (s *Service) ScheduleDeletion(ctx context.Context, user model.User) (time.Time, error) {
+	if user.DeletedAt != nil {
+		return time.Time{}, ErrAlreadyDeleted
+	}
+
+	now := time.Now()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.User{}).Where("id_user = ? AND deleted_at IS NULL", user.IdUser).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyDeleted
+		}
+
+		for _, record := range []interface{}{&model.Resume{}, &model.JobApplication{}} {
+			if err := tx.Model(record).Where("id_user = ? AND deleted_at IS NULL", user.IdUser).Update("deleted_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&model.PersonalAccessToken{}).Where("id_user = ? AND revoked_at IS NULL", user.IdUser).Update("revoked_at", now).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlreadyDeleted) {
+			return time.Time{}, err
+		}
+		return time.Time{}, fmt.Errorf("failed to delete account: %w", err)
+	}
+
+	// Workflows are only looked up once the applications are deleted, as no new
+	// run starts for a deleted application. One that reserved its run before
+	// then is stopped by whoever started it.
+	var pending []model.JobApplication
+	if err := s.db.WithContext(ctx).Where("id_user = ? AND status = ? AND workflow_id IS NOT NULL", user.IdUser, model.JobApplicationStatusPending).Find(&pending).Error; err != nil {
+		s.logger.Printf("Failed to load pending job applications of user %d: %v", user.IdUser, err)
+	}
+	for _, jobApplication := range pending {
+		s.cancelWorkflow(ctx, jobApplication)
+	}
+
+	if err := s.sessions.RevokeAllForUser(user.IdUser, 0, model.SessionRevokedAccountDeleted); err != nil {
+		return time.Time{}, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return now.Add(s.gracePeriod), nil
+}
+
+// PurgeExpired permanently removes accounts whose grace period has ended and
+// returns how many were purged
+func (s *Service) PurgeExpired(ctx context.Context) (int, error) {
+	var users []model.User
+	if err := s.db.WithContext(ctx).Where("deleted_at IS NOT NULL AND deleted_at <= ?", time.Now().Add(-s.gracePeriod)).Find(&users).Error; err != nil {
+		return 0, fmt.Errorf("failed to find accounts to purge: %w", err)
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err := s.purge(ctx, user); err != nil {
+			s.logger.Printf("Failed to purge account %s: %v", user.IdExternal, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunPurger calls PurgeExpired every interval until ctx is cancelled
+func (s *Service) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.PurgeExpired(ctx)
+		if err != nil {
+			s.logger.Printf("Account purge failed: %v", err)
+		} else if purged > 0 {
+			s.logger.Printf("Purged %d deleted accounts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) purge(ctx context.Context, user model.User) error {
+	var resumes []model.Resume
+	if err := s.db.WithContext(ctx).Where("id_user = ?", user.IdUser).Find(&resumes).Error; err != nil {
+		return err
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, record := range []interface{}{
+			&model.JobApplicationBatchItem{},
+			&model.JobApplicationBatch{},
+			&model.JobApplicationStatusChange{},
+			&model.JobApplicationAttempt{},
+			&model.JobApplication{},
+			&model.Resume{},
+			&model.Session{},
+			&model.UserToken{},
+			&model.RecoveryCode{},
+			&model.LinkedIdentity{},
+			&model.PersonalAccessToken{},
+			&model.AccountLockout{},
+			&model.WebAuthnCredential{},
+			&model.Consent{},
+		} {
+			if err := tx.Where("id_user = ?", user.IdUser).Delete(record).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id_user = ?", user.IdUser).Delete(&model.User{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, resume := range resumes {
+		if path, ok := s.localResumePath(resume); ok {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				s.logger.Printf("Failed to remove resume file %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) cancelWorkflow(ctx context.Context, jobApplication model.JobApplication) {
+	if jobApplication.WorkflowId == "" {
+		return
+	}
+	err := s.temporalClient.CancelWorkflow(ctx, jobApplication.WorkflowId, jobApplication.RunId)
+	var notFound *serviceerror.NotFound
+	if err != nil && !errors.As(err, &notFound) {
+		s.logger.Printf("Failed to cancel workflow %s: %v", jobApplication.WorkflowId, err)
+	}
+}
+
+// localResumePath returns the resume's file path when the original is stored
+// on disk. The path is only trusted when it resolves to a regular file inside
+// the resume directory, as the file is read into exports and removed on purge.
+func (s *Service) localResumePath(resume model.Resume) (string, bool) {
+	if s.resumeDir == "" || resume.Url == "" || strings.Contains(resume.Url, "://") {
+		return "", false
+	}
+
+	root, err := filepath.EvalSymlinks(s.resumeDir)
+	if err != nil {
+		s.logger.Printf("Failed to resolve resume directory: %v", err)
+		return "", false
+	}
+	path := resume.Url
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	// Resolving symlinks stops a link inside the directory pointing outside it
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		s.logger.Printf("Skipping resume %s stored outside the resume directory", resume.IdExternal)
+		return "", false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return path, true
+}
+
+func addJSON(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %w", name, err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func addFile(archive *zip.Writer, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
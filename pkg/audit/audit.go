@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Event describes an action to record. UserId defaults to the authenticated
+// user of the request when left nil. Events outlive the accounts they refer
+// to, so Metadata must not hold personal data such as email addresses; refer
+// to users by UserId instead.
+type Event struct {
+	Action     model.AuditAction
+	Outcome    model.AuditOutcome
+	UserId     *uint
+	TargetType string
+	TargetId   string
+	Metadata   map[string]interface{}
+}
+
+// Recorder writes audit events. Recording never fails the request it
+// describes; errors are logged instead.
+type Recorder struct {
+	db     *gorm.DB
+	logger *log.Logger
+}
+
+func NewRecorder(db *gorm.DB, logger *log.Logger) *Recorder {
+	return &Recorder{db: db, logger: logger}
+}
+
+// Record stores event with the client address and user agent of the request
+// and the authenticated user, if any, as the actor. While an admin is
+// impersonating a user the admin is the actor.
+func (r *Recorder) Record(c *gin.Context, event Event) {
+	auditEvent := model.AuditEvent{
+		UserId:     event.UserId,
+		Action:     event.Action,
+		Outcome:    event.Outcome,
+		TargetType: event.TargetType,
+		TargetId:   event.TargetId,
+		IpAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+
+	if impersonatorId := c.GetUint("impersonatorId"); impersonatorId != 0 {
+		auditEvent.ActorId = &impersonatorId
+		if auditEvent.UserId == nil {
+			userId := c.GetUint("userId")
+			auditEvent.UserId = &userId
+		}
+	} else if actorId := c.GetUint("userId"); actorId != 0 {
+		auditEvent.ActorId = &actorId
+		if auditEvent.UserId == nil {
+			auditEvent.UserId = &actorId
+		}
+	} else if event.Outcome == model.AuditOutcomeSuccess {
+		// Unauthenticated requests that succeed, like logins, act as the user
+		auditEvent.ActorId = event.UserId
+	}
+
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			r.logger.Printf("Failed to encode audit metadata for %s: %v", event.Action, err)
+		} else {
+			auditEvent.Metadata = string(metadata)
+		}
+	}
+
+	if err := r.db.Create(&auditEvent).Error; err != nil {
+		r.logger.Printf("Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// UserId is a helper for filling Event.UserId
+func UserId(id uint) *uint {
+	return &id
+}
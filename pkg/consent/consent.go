@@ -0,0 +1,178 @@
+package consent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrVersionExists   = errors.New("this version has already been published")
+	ErrUnknownDocument = errors.New("document is not a current legal document")
+)
+
+// DocumentDTO describes a legal document to clients deciding what to show
+type DocumentDTO struct {
+	Id          string                  `json:"id"`
+	Kind        model.LegalDocumentKind `json:"kind"`
+	Version     string                  `json:"version"`
+	Title       string                  `json:"title"`
+	Url         string                  `json:"url"`
+	EffectiveAt time.Time               `json:"effectiveAt"`
+}
+
+func ToDTO(document model.LegalDocument) DocumentDTO {
+	return DocumentDTO{
+		Id:          document.IdExternal.String(),
+		Kind:        document.Kind,
+		Version:     document.Version,
+		Title:       document.Title,
+		Url:         document.Url,
+		EffectiveAt: document.EffectiveAt,
+	}
+}
+
+func ToDTOs(documents []model.LegalDocument) []DocumentDTO {
+	dtos := make([]DocumentDTO, 0, len(documents))
+	for _, document := range documents {
+		dtos = append(dtos, ToDTO(document))
+	}
+	return dtos
+}
+
+// Service publishes versioned legal documents and tracks which versions each
+// user has accepted
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Publish stores a new version of a legal document. Users must accept it once
+// its EffectiveAt has passed, which defaults to now.
+func (s *Service) Publish(document *model.LegalDocument) error {
+	if document.EffectiveAt.IsZero() {
+		document.EffectiveAt = time.Now()
+	}
+
+	var existing int64
+	if err := s.db.Model(&model.LegalDocument{}).Where("kind = ? AND version = ?", document.Kind, document.Version).Count(&existing).Error; err != nil {
+		return fmt.Errorf("failed to check legal document version: %w", err)
+	}
+	if existing > 0 {
+		return ErrVersionExists
+	}
+
+	if err := s.db.Create(document).Error; err != nil {
+		return fmt.Errorf("failed to publish legal document: %w", err)
+	}
+	return nil
+}
+
+// Current returns the latest effective version of each kind of document.
+// Kinds that have never been published are left out, so nothing needs
+// accepting until a document exists.
+func (s *Service) Current() ([]model.LegalDocument, error) {
+	now := time.Now()
+	var documents []model.LegalDocument
+	for _, kind := range model.AllLegalDocumentKinds {
+		var document model.LegalDocument
+		err := s.db.Where("kind = ? AND effective_at <= ?", kind, now).Order("effective_at DESC, id_legal_document DESC").First(&document).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load current %s: %w", kind, err)
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// Outstanding returns the current documents userId has not accepted yet
+func (s *Service) Outstanding(userId uint) ([]model.LegalDocument, error) {
+	current, err := s.Current()
+	if err != nil || len(current) == 0 {
+		return nil, err
+	}
+
+	documentIds := make([]uint, 0, len(current))
+	for _, document := range current {
+		documentIds = append(documentIds, document.IdLegalDocument)
+	}
+	var accepted []uint
+	if err := s.db.Model(&model.Consent{}).Where("id_user = ? AND id_legal_document IN ?", userId, documentIds).Pluck("id_legal_document", &accepted).Error; err != nil {
+		return nil, fmt.Errorf("failed to load consents: %w", err)
+	}
+
+	acceptedSet := make(map[uint]bool, len(accepted))
+	for _, id := range accepted {
+		acceptedSet[id] = true
+	}
+	var outstanding []model.LegalDocument
+	for _, document := range current {
+		if !acceptedSet[document.IdLegalDocument] {
+			outstanding = append(outstanding, document)
+		}
+	}
+	return outstanding, nil
+}
+
+// Resolve maps the external ids a client accepted to current documents and
+// returns the current documents those ids leave out. Ids of superseded or
+// unknown documents are ErrUnknownDocument, so a stale page cannot record
+// consent to terms that no longer apply.
+func (s *Service) Resolve(documentIds []string) (accepted []model.LegalDocument, missing []model.LegalDocument, err error) {
+	current, err := s.Current()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byId := make(map[string]model.LegalDocument, len(current))
+	for _, document := range current {
+		byId[document.IdExternal.String()] = document
+	}
+	acceptedSet := make(map[uint]bool, len(documentIds))
+	for _, id := range documentIds {
+		document, ok := byId[id]
+		if !ok {
+			return nil, nil, ErrUnknownDocument
+		}
+		if !acceptedSet[document.IdLegalDocument] {
+			acceptedSet[document.IdLegalDocument] = true
+			accepted = append(accepted, document)
+		}
+	}
+
+	for _, document := range current {
+		if !acceptedSet[document.IdLegalDocument] {
+			missing = append(missing, document)
+		}
+	}
+	return accepted, missing, nil
+}
+
+// Accept records that userId accepted documents within tx. Accepting a
+// version twice keeps the original record.
+func (s *Service) Accept(tx *gorm.DB, userId uint, documents []model.LegalDocument, ipAddress string, userAgent string) error {
+	now := time.Now()
+	for _, document := range documents {
+		record := model.Consent{
+			UserId:          userId,
+			LegalDocumentId: document.IdLegalDocument,
+			IpAddress:       ipAddress,
+			UserAgent:       userAgent,
+			AcceptedAt:      now,
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to record consent: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package invite
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"gorm.io/gorm"
+)
+
+// Signup modes selected with SIGNUP_MODE
+const (
+	SignupModeOpen   = "open"
+	SignupModeInvite = "invite"
+)
+
+// Codes use an alphabet without look-alike characters so they survive being
+// read out or retyped
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 10
+)
+
+var (
+	ErrInviteRequired = errors.New("an invite code is required to sign up")
+	ErrInvalidCode    = errors.New("invite code is invalid, expired or fully used")
+)
+
+// Service issues and redeems invite codes
+type Service struct {
+	db       *gorm.DB
+	required bool
+}
+
+func NewService(db *gorm.DB, required bool) *Service {
+	return &Service{db: db, required: required}
+}
+
+// RequiredFromEnv reports whether SIGNUP_MODE makes signup invite-only
+func RequiredFromEnv() (bool, error) {
+	switch mode := os.Getenv("SIGNUP_MODE"); mode {
+	case "", SignupModeOpen:
+		return false, nil
+	case SignupModeInvite:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid SIGNUP_MODE %q, expected %q or %q", mode, SignupModeOpen, SignupModeInvite)
+	}
+}
+
+// Required reports whether new accounts need an invite code
+func (s *Service) Required() bool {
+	return s.required
+}
+
+// Create issues a new invite code
+func (s *Service) Create(invite *model.Invite) error {
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	invite.Code = code
+	if invite.MaxUses < 1 {
+		invite.MaxUses = 1
+	}
+
+	if err := s.db.Create(invite).Error; err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+	return nil
+}
+
+// Redeem uses up one redemption of code within tx, so a failed signup in the
+// same transaction gives it back. An empty code is ErrInviteRequired when
+// invites are required and returns nil otherwise.
+func (s *Service) Redeem(tx *gorm.DB, code string) (*model.Invite, error) {
+	code = NormalizeCode(code)
+	if code == "" {
+		if s.required {
+			return nil, ErrInviteRequired
+		}
+		return nil, nil
+	}
+
+	now := time.Now()
+	result := tx.Model(&model.Invite{}).
+		Where("code = ? AND revoked_at IS NULL AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)", code, now).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to redeem invite: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidCode
+	}
+
+	var invite model.Invite
+	if err := tx.Where("code = ?", code).First(&invite).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invite: %w", err)
+	}
+	return &invite, nil
+}
+
+// NormalizeCode uppercases a code and strips the separators people add when
+// copying it
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// FormatCode splits a code in two halves for display, e.g. ABCDE-FGHJK
+func FormatCode(code string) string {
+	if len(code) != codeLength {
+		return code
+	}
+	return code[:codeLength/2] + "-" + code[codeLength/2:]
+}
+
+func generateCode() (string, error) {
+	buf := make([]byte, codeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	// 256 is a multiple of the alphabet size, so this is not biased
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
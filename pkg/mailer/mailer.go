@@ -0,0 +1,179 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Config selects and configures a Mailer implementation
+type Config struct {
+	// One of "smtp", "file" or "log". Defaults to "log".
+	Driver       string
+	From         string
+	FileDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// ConfigFromEnv reads the mailer configuration from the environment
+func ConfigFromEnv() Config {
+	return Config{
+		Driver:       os.Getenv("MAILER"),
+		From:         os.Getenv("MAIL_FROM"),
+		FileDir:      os.Getenv("MAILER_FILE_DIR"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+// New creates the Mailer described by config
+func New(config Config, logger *log.Logger) (Mailer, error) {
+	from := config.From
+	if from == "" {
+		from = "Iris <no-reply@localhost>"
+	}
+
+	switch config.Driver {
+	case "", "log":
+		return NewLogMailer(logger, from), nil
+	case "file":
+		dir := config.FileDir
+		if dir == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			dir = filepath.Join(homeDir, "iris", "mail")
+		}
+		return NewFileMailer(dir, from)
+	case "smtp":
+		if config.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mailer")
+		}
+		port := config.SMTPPort
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(config.SMTPHost, port, config.SMTPUsername, config.SMTPPassword, from), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", config.Driver)
+	}
+}
+
+// LogMailer writes emails to the application log. Useful for local development.
+type LogMailer struct {
+	logger *log.Logger
+	from   string
+}
+
+func NewLogMailer(logger *log.Logger, from string) *LogMailer {
+	return &LogMailer{logger: logger, from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	m.logger.Printf("Email from %s to %s\nSubject: %s\n\n%s", m.from, message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileMailer writes every email as a .eml file into a directory
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitizeFileName(message.To))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, buildMessage(m.from, message), 0644); err != nil {
+		return fmt.Errorf("failed to write email to %s: %w", path, err)
+	}
+	return nil
+}
+
+// SMTPMailer delivers emails through an SMTP relay
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{host: host, port: port, username: username, password: password, from: from}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	err := smtp.SendMail(net.JoinHostPort(m.host, m.port), auth, envelopeAddress(m.from), []string{message.To}, buildMessage(m.from, message))
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", message.To, err)
+	}
+	return nil
+}
+
+func buildMessage(from string, message Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + message.To + "\r\n")
+	b.WriteString("Subject: " + message.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// envelopeAddress extracts the bare address from "Name <address>"
+func envelopeAddress(from string) string {
+	if start := strings.LastIndex(from, "<"); start != -1 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			return from[start+1 : end]
+		}
+	}
+	return from
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,210 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/SomtoJF/iris-api/pkg/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	Issuer = "Iris"
+
+	RecoveryCodeCount = 10
+
+	// How long a user has to enter their code after the first login factor
+	LoginChallengeTTL = 5 * time.Minute
+)
+
+var (
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrNotEnrolling   = errors.New("two-factor authentication setup has not been started")
+	ErrInvalidCode    = errors.New("invalid authentication code")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Service manages TOTP enrollment, verification and recovery codes
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// BeginEnrollment generates a new pending secret for the user and returns it
+// together with its otpauth URI. The secret is not enforced until confirmed.
+func (s *Service) BeginEnrollment(user model.User) (string, string, error) {
+	if user.IsTotpEnabled() {
+		return "", "", ErrAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.db.Model(&model.User{}).Where("id_user = ?", user.IdUser).Update("totp_secret", secret).Error; err != nil {
+		return "", "", fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return secret, totp.URI(secret, Issuer, user.Email), nil
+}
+
+// ConfirmEnrollment enables TOTP once the user proves their authenticator
+// produces valid codes, and returns a fresh set of recovery codes.
+func (s *Service) ConfirmEnrollment(user model.User, code string) ([]string, error) {
+	if user.IsTotpEnabled() {
+		return nil, ErrAlreadyEnabled
+	}
+	if user.TotpSecret == "" {
+		return nil, ErrNotEnrolling
+	}
+
+	step, ok := totp.Validate(user.TotpSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id_user = ?", user.IdUser).Updates(map[string]interface{}{
+			"totp_enabled_at":     time.Now(),
+			"totp_last_used_step": step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.IdUser)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code or, failing that, a recovery code. A recovery
+// code is burned when it matches.
+func (s *Service) Verify(user model.User, code string) error {
+	if !user.IsTotpEnabled() {
+		return ErrNotEnabled
+	}
+
+	if step, ok := totp.Validate(user.TotpSecret, code, time.Now()); ok {
+		// Each code is accepted once; the update also loses the race when two
+		// requests present the same code concurrently.
+		result := s.db.Model(&model.User{}).
+			Where("id_user = ? AND totp_last_used_step < ?", user.IdUser, step).
+			Update("totp_last_used_step", step)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record TOTP use: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	result := s.db.Model(&model.RecoveryCode{}).
+		Where("id_user = ? AND code_hash = ? AND used_at IS NULL", user.IdUser, securetoken.Hash(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to use recovery code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Disable turns off TOTP and discards the secret and recovery codes
+func (s *Service) Disable(user model.User) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id_user = ?", user.IdUser).Updates(map[string]interface{}{
+			"totp_secret":         "",
+			"totp_enabled_at":     nil,
+			"totp_last_used_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id_user = ?", user.IdUser).Delete(&model.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and returns new ones
+func (s *Service) RegenerateRecoveryCodes(user model.User) ([]string, error) {
+	if !user.IsTotpEnabled() {
+		return nil, ErrNotEnabled
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.IdUser)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has
+func (s *Service) RemainingRecoveryCodes(user model.User) (int64, error) {
+	var count int64
+	err := s.db.Model(&model.RecoveryCode{}).Where("id_user = ? AND used_at IS NULL", user.IdUser).Count(&count).Error
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+	if err := tx.Where("id_user = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	records := make([]model.RecoveryCode, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, model.RecoveryCode{UserId: userId, CodeHash: securetoken.Hash(normalizeRecoveryCode(code))})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code formatted as xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
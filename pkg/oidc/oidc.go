@@ -0,0 +1,109 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidIDToken  = errors.New("invalid ID token")
+)
+
+// Identity is the user information asserted by an identity provider
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+// Provider is an external identity provider supporting the authorization
+// code flow with PKCE
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns the URL the user is redirected to in order to sign in
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	// Authenticate exchanges an authorization code for the user's identity
+	Authenticate(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error)
+}
+
+// ProviderConfig holds the OAuth client registration for a provider
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// ProvidersFromEnv builds the providers listed in OIDC_PROVIDERS. Each provider
+// is configured through OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and,
+// for OpenID Connect providers, OIDC_<NAME>_ISSUER. GitHub does not implement
+// OpenID Connect and is handled by a dedicated OAuth2 provider.
+func ProvidersFromEnv(apiUrl string) (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  fmt.Sprintf("%s/auth/oidc/%s/callback", strings.TrimSuffix(apiUrl, "/"), name),
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(scopes)
+		}
+
+		if config.ClientID == "" {
+			return nil, fmt.Errorf("%sCLIENT_ID is required", prefix)
+		}
+
+		if name == "github" && config.Issuer == "" {
+			providers[name] = NewGitHubProvider(config, httpClient)
+			continue
+		}
+
+		if config.Issuer == "" && name == "google" {
+			config.Issuer = "https://accounts.google.com"
+		}
+		if config.Issuer == "" {
+			return nil, fmt.Errorf("%sISSUER is required", prefix)
+		}
+		providers[name] = NewOIDCProvider(config, httpClient)
+	}
+
+	return providers, nil
+}
+
+// GenerateCodeVerifier returns a PKCE code verifier (RFC 7636)
+func GenerateCodeVerifier() (string, error) {
+	return securetoken.Generate()
+}
+
+// CodeChallengeS256 derives the S256 code challenge for a verifier
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
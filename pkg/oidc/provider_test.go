@@ -0,0 +1,300 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SomtoJF/iris-api/pkg/oidc"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testClientID     = "iris-client"
+	testClientSecret = "iris-secret"
+	testRedirectURL  = "https://api.example.com/auth/oidc/mock/callback"
+	testKeyId        = "mock-key"
+)
+
+// mockIssuer is an OpenID Connect issuer serving discovery, a key set and a
+// token endpoint that enforces PKCE. Authorization is done in-process by
+// approve, standing in for the user signing in at the provider.
+type mockIssuer struct {
+	server *httptest.Server
+	// Published in the key set and used to sign ID tokens
+	key *rsa.PrivateKey
+	// Signs ID tokens instead of key when set, under key's kid
+	forgeryKey *rsa.PrivateKey
+	// Merged into the claims of the ID tokens issued from now on, a nil value
+	// removes the claim
+	claims jwt.MapClaims
+
+	mu             sync.Mutex
+	authorizations map[string]authorization
+}
+
+type authorization struct {
+	codeChallenge string
+	nonce         string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	issuer := &mockIssuer{
+		key:            newRSAKey(t),
+		claims:         jwt.MapClaims{},
+		authorizations: make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyId,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(issuer.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuer.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+func (m *mockIssuer) provider() *oidc.OIDCProvider {
+	return oidc.NewOIDCProvider(oidc.ProviderConfig{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, m.server.Client())
+}
+
+// approve checks the authorization request the user was sent to and returns
+// the code the provider would redirect back with
+func (m *mockIssuer) approve(t *testing.T, authURL string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("response_type") != "code" || query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL does not use PKCE: %s", authURL)
+	}
+
+	codeBytes := make([]byte, 16)
+	if _, err := rand.Read(codeBytes); err != nil {
+		t.Fatalf("generating code: %v", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(codeBytes)
+	m.mu.Lock()
+	m.authorizations[code] = authorization{codeChallenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("client_secret") != testClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	auth, ok := m.authorizations[r.PostForm.Get("code")]
+	delete(m.authorizations, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != testRedirectURL {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"given_name":     "Ada",
+		"family_name":    "Lovelace",
+		"nonce":          auth.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range m.claims {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = testKeyId
+	signingKey := m.key
+	if m.forgeryKey != nil {
+		signingKey = m.forgeryKey
+	}
+	signed, err := idToken.SignedString(signingKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// signIn runs the authorization code flow against issuer as the endpoint
+// does. The user is sent off with the code verifier "verifier" and the nonce
+// "nonce", while verifier and nonce are what the login state holds on return.
+func signIn(t *testing.T, issuer *mockIssuer, provider *oidc.OIDCProvider, verifier string, nonce string) (*oidc.Identity, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oidc.CodeChallengeS256("verifier"))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	return provider.Authenticate(ctx, issuer.approve(t, authURL), verifier, nonce)
+}
+
+func TestAuthenticate(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	identity, err := signIn(t, issuer, issuer.provider(), "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	want := oidc.Identity{
+		Provider:      "mock",
+		Subject:       "subject-1",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		GivenName:     "Ada",
+		FamilyName:    "Lovelace",
+	}
+	if *identity != want {
+		t.Fatalf("identity is %+v, want %+v", *identity, want)
+	}
+}
+
+func TestAuthenticateRejectsWrongCodeVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	_, err := signIn(t, issuer, issuer.provider(), "another-verifier", "nonce")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("expected the token endpoint to refuse the code, got %v", err)
+	}
+}
+
+func TestAuthenticateRejectsNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	_, err := signIn(t, issuer, issuer.provider(), "verifier", "another-nonce")
+	if !errors.Is(err, oidc.ErrInvalidIDToken) || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestAuthenticateRejectsBadSignature(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.forgeryKey = newRSAKey(t)
+
+	_, err := signIn(t, issuer, issuer.provider(), "verifier", "nonce")
+	if !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+}
+
+func TestAuthenticateRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"missing exp", jwt.MapClaims{"exp": nil}},
+		{"other audience", jwt.MapClaims{"aud": "someone-else"}},
+		{"other issuer", jwt.MapClaims{"iss": "https://evil.test"}},
+		{"missing subject", jwt.MapClaims{"sub": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			issuer.claims = test.claims
+
+			_, err := signIn(t, issuer, issuer.provider(), "verifier", "nonce")
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateReportsUnverifiedEmail(t *testing.T) {
+	for _, emailVerified := range []interface{}{false, "false", nil} {
+		issuer := newMockIssuer(t)
+		issuer.claims = jwt.MapClaims{"email_verified": emailVerified}
+
+		identity, err := signIn(t, issuer, issuer.provider(), "verifier", "nonce")
+		if err != nil {
+			t.Fatalf("Authenticate with email_verified %v: %v", emailVerified, err)
+		}
+		if identity.EmailVerified {
+			t.Fatalf("email_verified %v was reported as verified", emailVerified)
+		}
+	}
+}
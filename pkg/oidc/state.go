@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const StateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("login state is invalid or has expired")
+
+// LoginState is everything needed to finish a login after the provider
+// redirects back, keyed by the OAuth state parameter
+type LoginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+}
+
+// StateStore keeps pending logins in Redis so each state can be redeemed once
+type StateStore struct {
+	client *redis.Client
+}
+
+func NewStateStore(client *redis.Client) *StateStore {
+	return &StateStore{client: client}
+}
+
+func (s *StateStore) getKey(state string) string {
+	return fmt.Sprintf("oidc:state:%s", state)
+}
+
+// Save stores a pending login for StateTTL
+func (s *StateStore) Save(ctx context.Context, state string, loginState LoginState) error {
+	payload, err := json.Marshal(loginState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal login state: %w", err)
+	}
+	if err := s.client.Set(ctx, s.getKey(state), payload, StateTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store login state: %w", err)
+	}
+	return nil
+}
+
+// Take returns and deletes a pending login
+func (s *StateStore) Take(ctx context.Context, state string) (*LoginState, error) {
+	payload, err := s.client.GetDel(ctx, s.getKey(state)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrInvalidState
+		}
+		return nil, fmt.Errorf("failed to load login state: %w", err)
+	}
+
+	var loginState LoginState
+	if err := json.Unmarshal([]byte(payload), &loginState); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal login state: %w", err)
+	}
+	return &loginState, nil
+}
@@ -0,0 +1,97 @@
+package onetimetoken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
+
+// Store issues and consumes single-use tokens backed by the user_token table
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+// Issue creates a new token for the user. Any unused token previously issued
+// for the same purpose is invalidated so only the latest one works.
+func (s *Store) Issue(userId uint, purpose model.UserTokenPurpose, ttl time.Duration, payload string) (string, error) {
+	token, err := securetoken.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.UserToken{}).
+			Where("id_user = ? AND purpose = ? AND consumed_at IS NULL", userId, purpose).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&model.UserToken{
+			UserId:    userId,
+			Purpose:   purpose,
+			TokenHash: securetoken.Hash(token),
+			Payload:   payload,
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to issue %s token: %w", purpose, err)
+	}
+	return token, nil
+}
+
+// Consume redeems a token. It succeeds at most once per token, even under
+// concurrent requests.
+func (s *Store) Consume(token string, purpose model.UserTokenPurpose) (*model.UserToken, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	tokenHash := securetoken.Hash(token)
+
+	result := s.db.Model(&model.UserToken{}).
+		Where("token_hash = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("consumed_at", now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume %s token: %w", purpose, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	var userToken model.UserToken
+	if err := s.db.Where("token_hash = ?", tokenHash).First(&userToken).Error; err != nil {
+		return nil, fmt.Errorf("failed to load %s token: %w", purpose, err)
+	}
+	return &userToken, nil
+}
+
+// Lookup returns a token that is still valid without consuming it
+func (s *Store) Lookup(token string, purpose model.UserTokenPurpose) (*model.UserToken, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var userToken model.UserToken
+	err := s.db.Where("token_hash = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", securetoken.Hash(token), purpose, time.Now()).
+		First(&userToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to look up %s token: %w", purpose, err)
+	}
+	return &userToken, nil
+}
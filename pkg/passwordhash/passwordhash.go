@@ -0,0 +1,216 @@
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2idPrefix = "$argon2id$"
+
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrUnknownFormat    = errors.New("unrecognised password hash format")
+)
+
+// Argon2Params are the Argon2id cost parameters for new hashes
+type Argon2Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for Argon2id
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+// Policy limits which passwords are accepted when one is set. Lengths count
+// characters, so long passphrases in any script are allowed.
+type Policy struct {
+	MinLength int
+	MaxLength int
+}
+
+// MaxPasswordLength caps Policy.MaxLength. Request bindings for passwords
+// that are only verified use it as their limit.
+const MaxPasswordLength = 256
+
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: MaxPasswordLength}
+
+// DefaultMaxConcurrent is how many passwords are hashed at once by default.
+// Each Argon2id hash holds Argon2Params.Memory for its duration, so this
+// bounds the memory a burst of logins can take.
+const DefaultMaxConcurrent = 4
+
+// Config configures a Hasher
+type Config struct {
+	Argon2        Argon2Params
+	Policy        Policy
+	MaxConcurrent int
+}
+
+// ConfigFromEnv reads the hashing parameters and password policy from the
+// environment, falling back to the defaults for unset values
+func ConfigFromEnv() (Config, error) {
+	config := Config{Argon2: DefaultArgon2Params, Policy: DefaultPolicy, MaxConcurrent: DefaultMaxConcurrent}
+
+	for _, setting := range []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"ARGON2_MEMORY_KIB", 32, func(v uint64) { config.Argon2.Memory = uint32(v) }},
+		{"ARGON2_ITERATIONS", 32, func(v uint64) { config.Argon2.Iterations = uint32(v) }},
+		{"ARGON2_PARALLELISM", 8, func(v uint64) { config.Argon2.Parallelism = uint8(v) }},
+		{"PASSWORD_MIN_LENGTH", 16, func(v uint64) { config.Policy.MinLength = int(v) }},
+		{"PASSWORD_MAX_LENGTH", 16, func(v uint64) { config.Policy.MaxLength = int(v) }},
+		{"PASSWORD_HASH_MAX_CONCURRENT", 16, func(v uint64) { config.MaxConcurrent = int(v) }},
+	} {
+		raw := os.Getenv(setting.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(raw, 10, setting.bits)
+		if err != nil || value == 0 {
+			return Config{}, fmt.Errorf("%s must be a positive integer", setting.name)
+		}
+		setting.set(value)
+	}
+
+	if config.Policy.MinLength > config.Policy.MaxLength {
+		return Config{}, fmt.Errorf("PASSWORD_MIN_LENGTH cannot exceed PASSWORD_MAX_LENGTH")
+	}
+	if config.Policy.MaxLength > MaxPasswordLength {
+		return Config{}, fmt.Errorf("PASSWORD_MAX_LENGTH cannot exceed %d", MaxPasswordLength)
+	}
+	return config, nil
+}
+
+// Hasher hashes new passwords with Argon2id and verifies both Argon2id and
+// legacy bcrypt hashes. Hashes are self-describing, so the algorithm and its
+// parameters can change without invalidating stored passwords.
+type Hasher struct {
+	params Argon2Params
+	policy Policy
+	// Semaphore limiting how many hashes are computed at once
+	slots chan struct{}
+}
+
+func New(config Config) *Hasher {
+	maxConcurrent := config.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrent
+	}
+	return &Hasher{params: config.Argon2, policy: config.Policy, slots: make(chan struct{}, maxConcurrent)}
+}
+
+// Policy returns the password policy enforced by Validate
+func (h *Hasher) Policy() Policy {
+	return h.policy
+}
+
+// Validate checks a new password against the policy
+func (h *Hasher) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < h.policy.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrPasswordTooShort, h.policy.MinLength)
+	}
+	if length > h.policy.MaxLength {
+		return fmt.Errorf("%w, use at most %d characters", ErrPasswordTooLong, h.policy.MaxLength)
+	}
+	return nil
+}
+
+// Hash returns the encoded Argon2id hash of password in the PHC string format
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	h.slots <- struct{}{}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, keyLength)
+	<-h.slots
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches the encoded hash, and whether the
+// hash should be replaced because it uses an outdated algorithm or parameters.
+// An empty hash, as on accounts created through social login, never matches.
+func (h *Hasher) Verify(password string, encoded string) (match bool, needsRehash bool, err error) {
+	switch {
+	case encoded == "":
+		return false, false, nil
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		h.slots <- struct{}{}
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		<-h.slots
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		return true, params != h.params, nil
+	case strings.HasPrefix(encoded, "$2"):
+		h.slots <- struct{}{}
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		<-h.slots
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	default:
+		return false, false, ErrUnknownFormat
+	}
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+	return params, salt, key, nil
+}
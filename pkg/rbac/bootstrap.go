@@ -0,0 +1,55 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/SomtoJF/iris-api/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAdminExists      = errors.New("an admin already exists")
+	ErrUserNotFound     = errors.New("no user exists with that email")
+	ErrEmailNotVerified = errors.New("user has not verified their email")
+)
+
+// BootstrapAdmin grants the admin role to the user with the given email when
+// no admin exists yet. Once there is an admin, further roles are managed
+// through the /admin routes. The email must be verified so the first person
+// to sign up with the address cannot claim the role.
+func BootstrapAdmin(db *gorm.DB, email string) (*model.User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var user model.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var admins int64
+		if err := tx.Model(&model.User{}).Where("role = ? AND deleted_at IS NULL", model.RoleAdmin).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return ErrAdminExists
+		}
+
+		if err := tx.Where("LOWER(email) = ? AND deleted_at IS NULL", email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if !user.IsEmailVerified() {
+			return ErrEmailNotVerified
+		}
+
+		user.Role = model.RoleAdmin
+		return tx.Model(&user).Update("role", model.RoleAdmin).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrAdminExists) || errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrEmailNotVerified) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to bootstrap admin: %w", err)
+	}
+	return &user, nil
+}
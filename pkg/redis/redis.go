@@ -16,6 +16,9 @@ type ActionType string
 const (
 	ActionApplicationSuccessful ActionType = "APPLICATION_SUCCESSFUL"
 	ActionApplicationFailed     ActionType = "APPLICATION_FAILED"
+	ActionApplicationCancelled  ActionType = "APPLICATION_CANCELLED"
+	ActionBatchItemUpdated      ActionType = "BATCH_ITEM_UPDATED"
+	ActionBatchCompleted        ActionType = "BATCH_COMPLETED"
 	ActionUserActionRequired    ActionType = "USER_ACTION_REQUIRED"
 )
 
@@ -319,3 +322,134 @@ func (r *RedisRateLimiter) GetActiveSlots(ctx context.Context, provider string,
 
 	return count, nil
 }
+
+// GetRateLimitKey returns the Redis key for a fixed-window request counter
+func (r *RedisRateLimiter) GetRateLimitKey(scope string, identifier string) string {
+	return fmt.Sprintf("ratelimit:%s:%s", scope, identifier)
+}
+
+// Allow counts a request against a fixed window of the given length.
+// Returns true if the request is within the limit, otherwise false and how long
+// the caller has to wait before the window resets.
+func (r *RedisRateLimiter) Allow(ctx context.Context, scope string, identifier string, limit int, window time.Duration) (bool, time.Duration, error) {
+	key := r.GetRateLimitKey(scope, identifier)
+
+	script := `
+		local current = redis.call('INCR', KEYS[1])
+		-- Start the window on the first request
+		if current == 1 then
+			redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		end
+
+		if current > tonumber(ARGV[1]) then
+			local ttl = redis.call('PTTL', KEYS[1])
+			if ttl < 0 then
+				redis.call('PEXPIRE', KEYS[1], ARGV[2])
+				ttl = tonumber(ARGV[2])
+			end
+			return ttl
+		end
+		return 0
+	`
+
+	result, err := r.client.Eval(ctx, script, []string{key}, limit, window.Milliseconds()).Result()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to execute rate limit script: %w", err)
+	}
+
+	retryAfter := time.Duration(result.(int64)) * time.Millisecond
+	return retryAfter == 0, retryAfter, nil
+}
+
+// LockoutPolicy controls when repeated failures lock an identifier out
+type LockoutPolicy struct {
+	// Failures allowed before the first lockout
+	Threshold int
+	// Length of the first lockout; each further failure doubles it
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// How long failures are remembered after the last one
+	FailureWindow time.Duration
+}
+
+// RedisLoginGuard tracks failed authentication attempts and applies
+// exponentially growing lockouts
+type RedisLoginGuard struct {
+	client *redis.Client
+}
+
+// NewRedisLoginGuard creates a new RedisLoginGuard instance
+func NewRedisLoginGuard(client *redis.Client) *RedisLoginGuard {
+	return &RedisLoginGuard{
+		client: client,
+	}
+}
+
+// GetFailuresKey returns the Redis key counting failures for an identifier
+func (r *RedisLoginGuard) GetFailuresKey(scope string, identifier string) string {
+	return fmt.Sprintf("login_guard:%s:%s:failures", scope, identifier)
+}
+
+// GetLockKey returns the Redis key that exists while an identifier is locked out
+func (r *RedisLoginGuard) GetLockKey(scope string, identifier string) string {
+	return fmt.Sprintf("login_guard:%s:%s:lock", scope, identifier)
+}
+
+// LockedFor returns how much longer an identifier is locked out, or 0
+func (r *RedisLoginGuard) LockedFor(ctx context.Context, scope string, identifier string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.GetLockKey(scope, identifier)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check lockout: %w", err)
+	}
+	// Negative values mean the key does not exist or has no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// RecordFailure counts a failed attempt and locks the identifier out once the
+// policy threshold is reached. Returns the failure count and the lockout that
+// was applied, which is 0 while still under the threshold.
+func (r *RedisLoginGuard) RecordFailure(ctx context.Context, scope string, identifier string, policy LockoutPolicy) (int, time.Duration, error) {
+	failuresKey := r.GetFailuresKey(scope, identifier)
+	lockKey := r.GetLockKey(scope, identifier)
+
+	script := `
+		local failures = redis.call('INCR', KEYS[1])
+		redis.call('PEXPIRE', KEYS[1], ARGV[4])
+
+		local threshold = tonumber(ARGV[1])
+		if failures < threshold then
+			return {failures, 0}
+		end
+
+		-- Double the lockout for every failure past the threshold
+		local lockout = tonumber(ARGV[2]) * (2 ^ (failures - threshold))
+		if lockout > tonumber(ARGV[3]) then
+			lockout = tonumber(ARGV[3])
+		end
+		lockout = math.floor(lockout)
+
+		redis.call('SET', KEYS[2], failures, 'PX', lockout)
+		return {failures, lockout}
+	`
+
+	result, err := r.client.Eval(ctx, script, []string{failuresKey, lockKey},
+		policy.Threshold, policy.BaseLockout.Milliseconds(), policy.MaxLockout.Milliseconds(), policy.FailureWindow.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to execute login guard script: %w", err)
+	}
+
+	values := result.([]interface{})
+	return int(values[0].(int64)), time.Duration(values[1].(int64)) * time.Millisecond, nil
+}
+
+// Reset clears the failures and any lockout for an identifier
+func (r *RedisLoginGuard) Reset(ctx context.Context, scope string, identifier string) error {
+	err := r.client.Del(ctx, r.GetFailuresKey(scope, identifier), r.GetLockKey(scope, identifier)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reset login guard: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package securetoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// DefaultSize is the number of random bytes used by Generate
+const DefaultSize = 32
+
+// Generate returns a URL-safe random token backed by DefaultSize random bytes
+func Generate() (string, error) {
+	return GenerateSize(DefaultSize)
+}
+
+// GenerateSize returns a URL-safe random token backed by size random bytes
+func GenerateSize(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash returns the hex encoded SHA-256 digest of a token. Tokens are only
+// ever stored in this form so a database leak does not expose usable secrets.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Equal compares two token hashes in constant time
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,374 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/SomtoJF/iris-api/pkg/signingkeys"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenCookieName  = "Access_Token"
+	RefreshTokenCookieName = "Refresh_Token"
+
+	// The CSRF token cookie is readable by the client, which echoes it back in
+	// CSRFTokenHeader on unsafe requests
+	CSRFTokenCookieName = "CSRF_Token"
+	CSRFTokenHeader     = "X-CSRF-Token"
+
+	// RefreshTokenCookiePath limits the refresh token cookie to the refresh endpoint
+	RefreshTokenCookiePath = "/refresh"
+
+	AccessTokenTTL   = 15 * time.Minute
+	SessionTTL       = 30 * 24 * time.Hour
+	ImpersonationTTL = time.Hour
+
+	// Last-seen details are only written this often to avoid a write per request
+	lastSeenResolution = time.Minute
+)
+
+var (
+	ErrInvalidAccessToken  = errors.New("invalid or expired access token")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionInactive     = errors.New("session has been revoked or has expired")
+)
+
+// Manager issues, rotates and revokes server-side sessions and the cookies
+// that carry them.
+type Manager struct {
+	db           *gorm.DB
+	keys         *signingkeys.Manager
+	clientDomain string
+}
+
+func NewManager(db *gorm.DB, keys *signingkeys.Manager, clientDomain string) *Manager {
+	return &Manager{db: db, keys: keys, clientDomain: clientDomain}
+}
+
+// Start creates a new session for the user and sets the access token,
+// refresh token and CSRF token cookies on the response.
+func (m *Manager) Start(c *gin.Context, user model.User) (*model.Session, error) {
+	return m.start(c, user, model.Session{ExpiresAt: time.Now().Add(SessionTTL)})
+}
+
+// StartImpersonation creates a short-lived session in which impersonator acts
+// as user. The access token names the impersonator in its "act" claim.
+func (m *Manager) StartImpersonation(c *gin.Context, user model.User, impersonator model.User, reason string) (*model.Session, error) {
+	return m.start(c, user, model.Session{
+		ExpiresAt:           time.Now().Add(ImpersonationTTL),
+		ImpersonatorId:      &impersonator.IdUser,
+		Impersonator:        &impersonator,
+		ImpersonationReason: reason,
+	})
+}
+
+func (m *Manager) start(c *gin.Context, user model.User, session model.Session) (*model.Session, error) {
+	secret, err := securetoken.Generate()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := securetoken.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.UserId = user.IdUser
+	session.RefreshTokenHash = securetoken.Hash(secret)
+	session.CsrfTokenHash = securetoken.Hash(csrfToken)
+	session.UserAgent = c.Request.UserAgent()
+	session.IpAddress = c.ClientIP()
+	session.LastSeenAt = &now
+	if err := m.db.Omit("User", "Impersonator").Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	if err := m.setCookies(c, user, session, secret, csrfToken); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Refresh rotates the refresh token and CSRF token of the session found in the
+// request cookies and issues a fresh access token. Presenting a refresh token that has already been
+// rotated out revokes the whole session, since it means the token leaked.
+func (m *Manager) Refresh(c *gin.Context) (*model.Session, error) {
+	presented, err := c.Cookie(RefreshTokenCookieName)
+	if err != nil || presented == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	sessionId, secret, ok := strings.Cut(presented, ".")
+	if !ok || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	if _, err := uuid.Parse(sessionId); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var session model.Session
+	if err := m.db.Preload("User").Preload("Impersonator").Where("id_external = ?", sessionId).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	if !session.IsActive(time.Now()) {
+		return nil, ErrSessionInactive
+	}
+
+	presentedHash := securetoken.Hash(secret)
+	if !securetoken.Equal(presentedHash, session.RefreshTokenHash) {
+		if err := m.Revoke(session.IdSession, model.SessionRevokedTokenReuse); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	newSecret, err := securetoken.Generate()
+	if err != nil {
+		return nil, err
+	}
+	newCsrfToken, err := securetoken.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only rotate if nobody else rotated the token in the meantime; a
+	// concurrent rotation is indistinguishable from reuse.
+	result := m.db.Model(&model.Session{}).
+		Where("id_session = ? AND refresh_token_hash = ?", session.IdSession, presentedHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": securetoken.Hash(newSecret),
+			"csrf_token_hash":    securetoken.Hash(newCsrfToken),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := m.Revoke(session.IdSession, model.SessionRevokedTokenReuse); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	session.CsrfTokenHash = securetoken.Hash(newCsrfToken)
+	if err := m.setCookies(c, session.User, session, newSecret, newCsrfToken); err != nil {
+		return nil, err
+	}
+
+	// Activity is informational, a failure to record it must not fail the refresh
+	_ = m.Touch(c, &session)
+	return &session, nil
+}
+
+// Authenticate validates an access token and returns the session it belongs
+// to. Tokens whose session was revoked or expired are rejected.
+func (m *Manager) Authenticate(tokenString string) (*model.Session, error) {
+	token, err := jwt.Parse(tokenString, m.keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidAccessToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidAccessToken
+	}
+
+	sessionId, ok := claims["sid"].(string)
+	if !ok {
+		return nil, ErrInvalidAccessToken
+	}
+
+	var session model.Session
+	if err := m.db.Where("id_external = ?", sessionId).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInactive
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	if !session.IsActive(time.Now()) {
+		return nil, ErrSessionInactive
+	}
+	return &session, nil
+}
+
+// Touch records the client's address, user agent and the time it was last
+// seen on the session
+func (m *Manager) Touch(c *gin.Context, session *model.Session) error {
+	now := time.Now()
+	userAgent, ipAddress := c.Request.UserAgent(), c.ClientIP()
+	if session.LastSeenAt != nil && now.Sub(*session.LastSeenAt) < lastSeenResolution &&
+		session.UserAgent == userAgent && session.IpAddress == ipAddress {
+		return nil
+	}
+
+	err := m.db.Model(&model.Session{}).Where("id_session = ?", session.IdSession).Updates(map[string]interface{}{
+		"last_seen_at": now,
+		"user_agent":   userAgent,
+		"ip_address":   ipAddress,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record session activity: %w", err)
+	}
+	session.LastSeenAt, session.UserAgent, session.IpAddress = &now, userAgent, ipAddress
+	return nil
+}
+
+// ActiveForUser lists a user's sessions that can still be used, most recently seen first
+func (m *Manager) ActiveForUser(userId uint) ([]model.Session, error) {
+	var sessions []model.Session
+	err := m.db.Where("id_user = ? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Order("last_seen_at DESC, created_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Revoke marks a single session as revoked
+func (m *Manager) Revoke(sessionId uint, reason model.SessionRevocationReason) error {
+	err := m.db.Model(&model.Session{}).
+		Where("id_session = ? AND revoked_at IS NULL", sessionId).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every active session of a user except the one
+// identified by exceptSessionId. Pass 0 to revoke all of them.
+func (m *Manager) RevokeAllForUser(userId uint, exceptSessionId uint, reason model.SessionRevocationReason) error {
+	err := m.db.Model(&model.Session{}).
+		Where("id_user = ? AND id_session <> ? AND revoked_at IS NULL", userId, exceptSessionId).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// ClearCookies removes the access token, refresh token and CSRF token cookies
+// from the client
+func (m *Manager) ClearCookies(c *gin.Context) {
+	secure, sameSite := m.cookiePolicy()
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     AccessTokenCookieName,
+		Value:    "",
+		Path:     "/",
+		Domain:   m.clientDomain,
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     RefreshTokenCookieName,
+		Value:    "",
+		Path:     RefreshTokenCookiePath,
+		Domain:   m.clientDomain,
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFTokenCookieName,
+		Value:    "",
+		Path:     "/",
+		Domain:   m.clientDomain,
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: false,
+		SameSite: sameSite,
+	})
+}
+
+// VerifyCSRFToken reports whether the token presented by the client matches
+// the one issued to the session. Sessions without a CSRF token never match.
+func VerifyCSRFToken(session *model.Session, presented string) bool {
+	if session.CsrfTokenHash == "" || presented == "" {
+		return false
+	}
+	return securetoken.Equal(securetoken.Hash(presented), session.CsrfTokenHash)
+}
+
+func (m *Manager) signAccessToken(user model.User, session model.Session) (string, error) {
+	claims := jwt.MapClaims{
+		"id":    user.IdExternal.String(),
+		"email": user.Email,
+		"sid":   session.IdExternal.String(),
+		"exp":   time.Now().Add(AccessTokenTTL).Unix(),
+	}
+	// Actor claim as in RFC 8693, marking the token as an impersonation
+	if session.Impersonator != nil {
+		claims["act"] = map[string]interface{}{
+			"id":    session.Impersonator.IdExternal.String(),
+			"email": session.Impersonator.Email,
+		}
+	}
+	return m.keys.Sign(claims)
+}
+
+func (m *Manager) setCookies(c *gin.Context, user model.User, session model.Session, refreshSecret string, csrfToken string) error {
+	accessToken, err := m.signAccessToken(user, session)
+	if err != nil {
+		return fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	secure, sameSite := m.cookiePolicy()
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     AccessTokenCookieName,
+		Value:    accessToken,
+		Path:     "/",
+		Domain:   m.clientDomain,
+		MaxAge:   int(AccessTokenTTL.Seconds()),
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     RefreshTokenCookieName,
+		Value:    session.IdExternal.String() + "." + refreshSecret,
+		Path:     RefreshTokenCookiePath,
+		Domain:   m.clientDomain,
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFTokenCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		Domain:   m.clientDomain,
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
+		Secure:   secure,
+		HttpOnly: false,
+		SameSite: sameSite,
+	})
+	return nil
+}
+
+func (m *Manager) cookiePolicy() (bool, http.SameSite) {
+	if strings.Contains(m.clientDomain, "localhost") {
+		return false, http.SameSiteDefaultMode
+	}
+	return true, http.SameSiteNoneMode
+}
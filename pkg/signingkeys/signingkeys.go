@@ -0,0 +1,297 @@
+package signingkeys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// ActiveFromHeader is the PEM header scheduling when a key starts signing,
+	// e.g. "Active-From: 2026-01-01T00:00:00Z". Every key must have one, as
+	// anything else, like the file's modification time, changes when the file
+	// is copied or restored.
+	ActiveFromHeader = "Active-From"
+
+	minRSAKeyBits = 2048
+)
+
+var (
+	ErrNoKeys     = errors.New("no signing keys configured")
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// Key is a private signing key identified by its kid
+type Key struct {
+	Id         string
+	Algorithm  string
+	ActiveFrom time.Time
+	private    crypto.Signer
+}
+
+func (k *Key) signingMethod() jwt.SigningMethod {
+	if k.Algorithm == jwt.SigningMethodEdDSA.Alg() {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JWK is the public half of a key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Manager loads RS256 and EdDSA keys from a directory of PEM files, one key
+// per file named <kid>.pem. The newest active key signs; a key that has been
+// superseded keeps verifying for the overlap window so tokens it signed stay
+// valid until they expire. Keys scheduled for the future are published ahead
+// of time so verifiers can cache them before they are used.
+type Manager struct {
+	dir     string
+	overlap time.Duration
+	logger  *log.Logger
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewManager loads the keys in dir and fails when there are none, so the
+// server never starts without a way to sign tokens
+func NewManager(dir string, overlap time.Duration, logger *log.Logger) (*Manager, error) {
+	m := &Manager{dir: dir, overlap: overlap, logger: logger}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	if _, err := m.signingKey(time.Now()); err != nil {
+		return nil, fmt.Errorf("%w in %s: none of the keys is active yet", ErrNoKeys, dir)
+	}
+	return m, nil
+}
+
+// Reload re-reads the key directory, picking up newly scheduled keys. The
+// previous keys are kept if the directory cannot be read or holds no keys.
+func (m *Manager) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%w in %s", ErrNoKeys, m.dir)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ActiveFrom.Equal(keys[j].ActiveFrom) {
+			return keys[i].Id < keys[j].Id
+		}
+		return keys[i].ActiveFrom.Before(keys[j].ActiveFrom)
+	})
+
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+	return nil
+}
+
+// RunReloader reloads the key directory every interval until ctx is cancelled
+func (m *Manager) RunReloader(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				m.logger.Printf("Failed to reload signing keys, keeping the current ones: %v", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the current key and sets the kid header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	key, err := m.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header,
+// for use with jwt.Parse
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+
+	for _, key := range m.verificationKeys(time.Now()) {
+		if key.Id != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public keys verifiers should trust, including keys
+// scheduled to become active
+func (m *Manager) JWKS() []JWK {
+	now := time.Now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(m.keys))
+	for _, key := range m.keys {
+		if m.retired(key, now) {
+			continue
+		}
+		jwks = append(jwks, toJWK(key))
+	}
+	return jwks
+}
+
+// signingKey returns the most recently activated key
+func (m *Manager) signingKey(now time.Time) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.keys) - 1; i >= 0; i-- {
+		if !m.keys[i].ActiveFrom.After(now) {
+			return m.keys[i], nil
+		}
+	}
+	return nil, ErrNoKeys
+}
+
+// verificationKeys returns the keys that may have signed a live token
+func (m *Manager) verificationKeys(now time.Time) []*Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(m.keys))
+	for _, key := range m.keys {
+		if key.ActiveFrom.After(now) || m.retired(key, now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// retired reports whether a newer key took over from key more than the
+// overlap window ago. Callers must hold m.mu.
+func (m *Manager) retired(key *Key, now time.Time) bool {
+	for _, other := range m.keys {
+		if other == key || !other.ActiveFrom.After(key.ActiveFrom) || other.ActiveFrom.After(now) {
+			continue
+		}
+		if now.Sub(other.ActiveFrom) > m.overlap {
+			return true
+		}
+	}
+	return false
+}
+
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{Id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		key.Algorithm, key.private = jwt.SigningMethodRS256.Alg(), private
+	case ed25519.PrivateKey:
+		key.Algorithm, key.private = jwt.SigningMethodEdDSA.Alg(), private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+
+	activeFrom, ok := block.Headers[ActiveFromHeader]
+	if !ok {
+		return nil, fmt.Errorf("missing %s header, add one such as \"%s: %s\" after the BEGIN line", ActiveFromHeader, ActiveFromHeader, time.Now().UTC().Format(time.RFC3339))
+	}
+	key.ActiveFrom, err = time.Parse(time.RFC3339, activeFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", ActiveFromHeader, err)
+	}
+	return key, nil
+}
+
+func toJWK(key *Key) JWK {
+	jwk := JWK{Kid: key.Id, Use: "sig", Alg: key.Algorithm}
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
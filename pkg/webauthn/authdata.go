@@ -0,0 +1,212 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent    byte = 0x01
+	flagUserVerified   byte = 0x04
+	flagBackupEligible byte = 0x08
+	flagBackedUp       byte = 0x10
+	flagAttestedData   byte = 0x40
+	flagExtensionData  byte = 0x80
+)
+
+// COSE key parameters
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+var cborHandle = &codec.CborHandle{}
+
+type attestationObject struct {
+	Fmt      string                 `codec:"fmt"`
+	AttStmt  map[string]interface{} `codec:"attStmt"`
+	AuthData []byte                 `codec:"authData"`
+}
+
+type attestedCredential struct {
+	Aaguid       []byte
+	CredentialId []byte
+	// COSE encoded public key
+	PublicKey []byte
+}
+
+type authenticatorData struct {
+	RpIdHash           []byte
+	Flags              byte
+	SignCount          uint32
+	AttestedCredential *attestedCredential
+}
+
+func parseAttestationObject(raw []byte) (*attestationObject, error) {
+	var attestation attestationObject
+	if err := codec.NewDecoderBytes(raw, cborHandle).Decode(&attestation); err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrVerification)
+	}
+	if len(attestation.AuthData) == 0 {
+		return nil, fmt.Errorf("%w: attestation object has no authenticator data", ErrVerification)
+	}
+	return &attestation, nil
+}
+
+// parseAuthenticatorData decodes the binary authenticator data structure
+// described in section 6.1 of the WebAuthn specification
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrVerification)
+	}
+
+	authData := &authenticatorData{
+		RpIdHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if authData.Flags&flagAttestedData == 0 {
+		return authData, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data is too short", ErrVerification)
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	if len(rest) < 18+idLength {
+		return nil, fmt.Errorf("%w: attested credential data is too short", ErrVerification)
+	}
+
+	// The public key is followed by extension data when the ED flag is set,
+	// so its length is only known once it has been decoded
+	keyData := rest[18+idLength:]
+	var key map[int64]interface{}
+	decoder := codec.NewDecoderBytes(keyData, cborHandle)
+	if err := decoder.Decode(&key); err != nil {
+		return nil, fmt.Errorf("%w: malformed credential public key", ErrVerification)
+	}
+	keyLength := decoder.NumBytesRead()
+	if authData.Flags&flagExtensionData == 0 && keyLength != len(keyData) {
+		return nil, fmt.Errorf("%w: trailing bytes after credential public key", ErrVerification)
+	}
+
+	authData.AttestedCredential = &attestedCredential{
+		Aaguid:       rest[:16],
+		CredentialId: rest[18 : 18+idLength],
+		PublicKey:    append([]byte{}, keyData[:keyLength]...),
+	}
+	return authData, nil
+}
+
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key holding an ES256, EdDSA or RS256 key
+func parsePublicKey(raw []byte) (*publicKey, error) {
+	var params map[int64]interface{}
+	if err := codec.NewDecoderBytes(raw, cborHandle).Decode(&params); err != nil {
+		return nil, fmt.Errorf("%w: malformed credential public key", ErrVerification)
+	}
+
+	keyType, _ := coseInt(params[coseKeyType])
+	alg, ok := coseInt(params[coseAlgorithm])
+	if !ok {
+		return nil, fmt.Errorf("%w: credential public key has no algorithm", ErrVerification)
+	}
+
+	switch {
+	case keyType == coseKeyTypeEC2 && alg == AlgES256:
+		curve, _ := coseInt(params[coseCurve])
+		x, xOk := params[coseX].([]byte)
+		y, yOk := params[coseY].([]byte)
+		if curve != coseCurveP256 || !xOk || !yOk || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: malformed P-256 public key", ErrVerification)
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{0x04}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid P-256 public key", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: key}, nil
+
+	case keyType == coseKeyTypeOKP && alg == AlgEdDSA:
+		curve, _ := coseInt(params[coseCurve])
+		x, xOk := params[coseX].([]byte)
+		if curve != coseCurveEd25519 || !xOk || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: malformed Ed25519 public key", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case keyType == coseKeyTypeRSA && alg == AlgRS256:
+		n, nOk := params[coseRSAN].([]byte)
+		e, eOk := params[coseRSAE].([]byte)
+		if !nOk || !eOk || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: malformed RSA public key", ErrVerification)
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA public key is too small", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: key}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported key type %d with algorithm %d", ErrVerification, keyType, alg)
+}
+
+func (k *publicKey) verify(data []byte, signature []byte) error {
+	var valid bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+	return nil
+}
+
+// coseInt reads an integer map value, which decodes as either signed or
+// unsigned depending on its sign
+func coseInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int64:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisChallengeStore keeps pending ceremonies in Redis for CeremonyTTL
+type RedisChallengeStore struct {
+	client *redis.Client
+}
+
+func NewRedisChallengeStore(client *redis.Client) *RedisChallengeStore {
+	return &RedisChallengeStore{client: client}
+}
+
+func (s *RedisChallengeStore) getKey(challenge string) string {
+	return fmt.Sprintf("webauthn:challenge:%s", challenge)
+}
+
+func (s *RedisChallengeStore) Save(ctx context.Context, challenge string, ceremony Ceremony) error {
+	payload, err := json.Marshal(ceremony)
+	if err != nil {
+		return fmt.Errorf("failed to marshal passkey ceremony: %w", err)
+	}
+	if err := s.client.Set(ctx, s.getKey(challenge), payload, CeremonyTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store passkey challenge: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisChallengeStore) Take(ctx context.Context, challenge string) (*Ceremony, error) {
+	payload, err := s.client.GetDel(ctx, s.getKey(challenge)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, fmt.Errorf("failed to load passkey challenge: %w", err)
+	}
+
+	var ceremony Ceremony
+	if err := json.Unmarshal([]byte(payload), &ceremony); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal passkey ceremony: %w", err)
+	}
+	return &ceremony, nil
+}
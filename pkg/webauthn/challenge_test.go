@@ -0,0 +1,162 @@
+package webauthn_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SomtoJF/iris-api/pkg/webauthn"
+	"github.com/SomtoJF/iris-api/pkg/webauthn/webauthntest"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis understands just enough RESP2 to serve SET and GETDEL, the two
+// commands RedisChallengeStore sends
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+}
+
+func startFakeRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &fakeRedis{listener: listener, values: make(map[string]string)}
+	go server.serve()
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), Protocol: 2, DisableIdentity: true})
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return client
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			s.mu.Lock()
+			s.values[args[1]] = args[2]
+			s.mu.Unlock()
+			reply = "+OK\r\n"
+		case "GETDEL":
+			s.mu.Lock()
+			value, ok := s.values[args[1]]
+			delete(s.values, args[1])
+			s.mu.Unlock()
+			if ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			} else {
+				reply = "$-1\r\n"
+			}
+		default:
+			reply = fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(header, "*") {
+		return nil, errors.New("expected an array")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+	if err != nil || count < 1 {
+		return nil, errors.New("malformed array length")
+	}
+
+	args := make([]string, 0, count)
+	for range count {
+		lengthLine, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(lengthLine, "$")))
+		if err != nil {
+			return nil, errors.New("malformed bulk string length")
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+func TestRedisChallengeStoreTakeIsSingleUse(t *testing.T) {
+	store := webauthn.NewRedisChallengeStore(startFakeRedis(t))
+	ctx := context.Background()
+
+	if err := store.Save(ctx, "challenge", webauthn.Ceremony{Type: webauthn.CeremonyRegistration, UserId: 7}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	ceremony, err := store.Take(ctx, "challenge")
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if ceremony.Type != webauthn.CeremonyRegistration || ceremony.UserId != 7 {
+		t.Fatalf("Take returned %+v", ceremony)
+	}
+
+	if _, err := store.Take(ctx, "challenge"); !errors.Is(err, webauthn.ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge on the second Take, got %v", err)
+	}
+	if _, err := store.Take(ctx, "unknown"); !errors.Is(err, webauthn.ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge for an unknown challenge, got %v", err)
+	}
+}
+
+func TestLoginRejectsReplayWithRedisChallengeStore(t *testing.T) {
+	rp := newRelyingParty(webauthn.NewRedisChallengeStore(startFakeRedis(t)))
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+	credential := register(t, rp, authenticator, user)
+
+	response := login(t, rp, authenticator, credential)
+	if _, err := rp.FinishLogin(context.Background(), *credential, user, *response); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if _, err := rp.FinishLogin(context.Background(), *credential, user, *response); !errors.Is(err, webauthn.ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge on replay, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package webauthn
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ConfigFromEnv reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and the comma separated
+// WEBAUTHN_ORIGINS, falling back to the host and origin of clientUrl
+func ConfigFromEnv(clientUrl string) (Config, error) {
+	parsed, err := url.Parse(clientUrl)
+	if err != nil || parsed.Host == "" {
+		return Config{}, fmt.Errorf("invalid client url %q", clientUrl)
+	}
+
+	config := Config{
+		RPID:    parsed.Hostname(),
+		RPName:  "Iris",
+		Origins: []string{parsed.Scheme + "://" + parsed.Host},
+	}
+	if rpId := os.Getenv("WEBAUTHN_RP_ID"); rpId != "" {
+		config.RPID = rpId
+	}
+	if rpName := os.Getenv("WEBAUTHN_RP_NAME"); rpName != "" {
+		config.RPName = rpName
+	}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		config.Origins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				config.Origins = append(config.Origins, strings.TrimSuffix(origin, "/"))
+			}
+		}
+	}
+	return config, nil
+}
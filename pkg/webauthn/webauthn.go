@@ -0,0 +1,421 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/securetoken"
+	"github.com/google/uuid"
+)
+
+// CeremonyTTL bounds how long a client has to complete a registration or
+// login once it has been given a challenge
+const CeremonyTTL = 5 * time.Minute
+
+var (
+	ErrInvalidChallenge = errors.New("passkey challenge is invalid or has expired")
+	ErrVerification     = errors.New("passkey verification failed")
+	// ErrSignCountRegressed means the authenticator reported a signature
+	// counter that did not increase, which points to a cloned credential
+	ErrSignCountRegressed = errors.New("passkey signature counter did not increase")
+)
+
+// COSE algorithm identifiers accepted for new credentials, in order of preference
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var supportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+type CeremonyType string
+
+const (
+	CeremonyRegistration CeremonyType = "webauthn.create"
+	CeremonyLogin        CeremonyType = "webauthn.get"
+)
+
+// Ceremony is the server side state of a registration or login in progress,
+// keyed by its challenge
+type Ceremony struct {
+	Type CeremonyType `json:"type"`
+	// Set for registrations, login ceremonies are not tied to a user up front
+	UserId uint `json:"userId,omitempty"`
+}
+
+// ChallengeStore keeps pending ceremonies so each challenge can be redeemed once
+type ChallengeStore interface {
+	Save(ctx context.Context, challenge string, ceremony Ceremony) error
+	// Take returns and deletes a pending ceremony, or ErrInvalidChallenge
+	Take(ctx context.Context, challenge string) (*Ceremony, error)
+}
+
+type Config struct {
+	// Domain the credentials are scoped to, e.g. example.com
+	RPID   string
+	RPName string
+	// Origins the browser may report, e.g. https://app.example.com
+	Origins []string
+}
+
+// RelyingParty runs the WebAuthn registration and login ceremonies. Only
+// "none" attestation is requested, so attestation statements are not verified.
+type RelyingParty struct {
+	config     Config
+	challenges ChallengeStore
+}
+
+func NewRelyingParty(config Config, challenges ChallengeStore) *RelyingParty {
+	return &RelyingParty{config: config, challenges: challenges}
+}
+
+type RelyingPartyEntity struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions.
+// Binary values are base64url encoded.
+type CreationOptions struct {
+	Rp                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RpId             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+	AttestationObject string   `json:"attestationObject" binding:"required"`
+	Transports        []string `json:"transports"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create()
+type RegistrationResponse struct {
+	Id       string              `json:"id" binding:"required"`
+	Type     string              `json:"type" binding:"required"`
+	Response AttestationResponse `json:"response" binding:"required"`
+}
+
+type AssertionResponseData struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get()
+type AssertionResponse struct {
+	Id       string                `json:"id" binding:"required"`
+	Type     string                `json:"type" binding:"required"`
+	Response AssertionResponseData `json:"response" binding:"required"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// BeginRegistration starts registering a new passkey for user. Credentials
+// the user already has are excluded so the same authenticator is not
+// registered twice.
+func (rp *RelyingParty) BeginRegistration(ctx context.Context, user model.User, existing []model.WebAuthnCredential) (*CreationOptions, error) {
+	challenge, err := rp.newChallenge(ctx, Ceremony{Type: CeremonyRegistration, UserId: user.IdUser})
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]CredentialParameter, 0, len(supportedAlgorithms))
+	for _, alg := range supportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	displayName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if displayName == "" {
+		displayName = user.Email
+	}
+
+	return &CreationOptions{
+		Rp: RelyingPartyEntity{Id: rp.config.RPID, Name: rp.config.RPName},
+		User: UserEntity{
+			Id:          UserHandle(user),
+			Name:        user.Email,
+			DisplayName: displayName,
+		},
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            CeremonyTTL.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration verifies the authenticator's response to a registration
+// ceremony started for user and returns the credential to store
+func (rp *RelyingParty) FinishRegistration(ctx context.Context, user model.User, response RegistrationResponse) (*model.WebAuthnCredential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrVerification, response.Type)
+	}
+
+	clientDataJSON, err := decodeBase64(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed client data", ErrVerification)
+	}
+	ceremony, err := rp.verifyClientData(ctx, clientDataJSON, CeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserId != user.IdUser {
+		return nil, ErrInvalidChallenge
+	}
+
+	rawAttestation, err := decodeBase64(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrVerification)
+	}
+	attestation, err := parseAttestationObject(rawAttestation)
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.AttestedCredential == nil {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+
+	attested := authData.AttestedCredential
+	if base64.RawURLEncoding.EncodeToString(attested.CredentialId) != strings.TrimRight(response.Id, "=") {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrVerification)
+	}
+
+	publicKey, err := parsePublicKey(attested.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(supportedAlgorithms, publicKey.alg) {
+		return nil, fmt.Errorf("%w: unsupported algorithm %d", ErrVerification, publicKey.alg)
+	}
+
+	aaguid, err := uuid.FromBytes(attested.Aaguid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed aaguid", ErrVerification)
+	}
+
+	return &model.WebAuthnCredential{
+		UserId:         user.IdUser,
+		CredentialId:   base64.RawURLEncoding.EncodeToString(attested.CredentialId),
+		PublicKey:      attested.PublicKey,
+		SignCount:      authData.SignCount,
+		Aaguid:         aaguid.String(),
+		Transports:     strings.Join(response.Response.Transports, ","),
+		BackupEligible: authData.Flags&flagBackupEligible != 0,
+		BackedUp:       authData.Flags&flagBackedUp != 0,
+	}, nil
+}
+
+// BeginLogin starts a login ceremony. With no allowed credentials the
+// browser offers any discoverable passkey for this relying party.
+func (rp *RelyingParty) BeginLogin(ctx context.Context, allowed []model.WebAuthnCredential) (*RequestOptions, error) {
+	challenge, err := rp.newChallenge(ctx, Ceremony{Type: CeremonyLogin})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          CeremonyTTL.Milliseconds(),
+		RpId:             rp.config.RPID,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishLogin verifies an assertion made with credential, the stored passkey
+// matching response.Id, and returns the authenticator's new signature counter
+func (rp *RelyingParty) FinishLogin(ctx context.Context, credential model.WebAuthnCredential, owner model.User, response AssertionResponse) (uint32, error) {
+	if response.Type != "public-key" {
+		return 0, fmt.Errorf("%w: unexpected credential type %q", ErrVerification, response.Type)
+	}
+
+	clientDataJSON, err := decodeBase64(response.Response.ClientDataJSON)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed client data", ErrVerification)
+	}
+	if _, err := rp.verifyClientData(ctx, clientDataJSON, CeremonyLogin); err != nil {
+		return 0, err
+	}
+
+	if strings.TrimRight(response.Id, "=") != credential.CredentialId {
+		return 0, fmt.Errorf("%w: credential id mismatch", ErrVerification)
+	}
+	if response.Response.UserHandle != "" && strings.TrimRight(response.Response.UserHandle, "=") != UserHandle(owner) {
+		return 0, fmt.Errorf("%w: user handle mismatch", ErrVerification)
+	}
+
+	rawAuthData, err := decodeBase64(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed authenticator data", ErrVerification)
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	signature, err := decodeBase64(response.Response.Signature)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed signature", ErrVerification)
+	}
+	publicKey, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := publicKey.verify(signed, signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that do not implement a counter always report zero
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+	return authData.SignCount, nil
+}
+
+// UserHandle is the opaque, base64url encoded user id given to authenticators.
+// The external id is used so internal ids never leave the server.
+func UserHandle(user model.User) string {
+	return base64.RawURLEncoding.EncodeToString(user.IdExternal[:])
+}
+
+// CredentialIdFromResponse returns the normalised credential id of an assertion
+func CredentialIdFromResponse(response AssertionResponse) string {
+	return strings.TrimRight(response.Id, "=")
+}
+
+func (rp *RelyingParty) newChallenge(ctx context.Context, ceremony Ceremony) (string, error) {
+	challenge, err := securetoken.Generate()
+	if err != nil {
+		return "", err
+	}
+	if err := rp.challenges.Save(ctx, challenge, ceremony); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// verifyClientData checks the browser-supplied client data and redeems the
+// challenge it carries, which makes every response single use
+func (rp *RelyingParty) verifyClientData(ctx context.Context, clientDataJSON []byte, ceremonyType CeremonyType) (*Ceremony, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, fmt.Errorf("%w: malformed client data", ErrVerification)
+	}
+	if data.Type != string(ceremonyType) {
+		return nil, fmt.Errorf("%w: unexpected client data type %q", ErrVerification, data.Type)
+	}
+	if !slices.Contains(rp.config.Origins, data.Origin) {
+		return nil, fmt.Errorf("%w: origin %q is not allowed", ErrVerification, data.Origin)
+	}
+
+	ceremony, err := rp.challenges.Take(ctx, strings.TrimRight(data.Challenge, "="))
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.Type != ceremonyType {
+		return nil, ErrInvalidChallenge
+	}
+	return ceremony, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData) error {
+	rpIdHash := sha256.Sum256([]byte(rp.config.RPID))
+	if !bytes.Equal(authData.RpIdHash, rpIdHash[:]) {
+		return fmt.Errorf("%w: relying party id mismatch", ErrVerification)
+	}
+	if authData.Flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user was not present", ErrVerification)
+	}
+	if authData.Flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user was not verified", ErrVerification)
+	}
+	return nil
+}
+
+func descriptors(credentials []model.WebAuthnCredential) []CredentialDescriptor {
+	result := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, CredentialDescriptor{
+			Type:       "public-key",
+			Id:         credential.CredentialId,
+			Transports: credential.TransportList(),
+		})
+	}
+	return result
+}
+
+// decodeBase64 accepts base64url with or without padding, as browsers and
+// client libraries differ
+func decodeBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
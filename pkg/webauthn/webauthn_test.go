@@ -0,0 +1,270 @@
+package webauthn_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SomtoJF/iris-api/model"
+	"github.com/SomtoJF/iris-api/pkg/webauthn"
+	"github.com/SomtoJF/iris-api/pkg/webauthn/webauthntest"
+	"github.com/google/uuid"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://app.example.com"
+)
+
+func newRelyingParty(challenges webauthn.ChallengeStore) *webauthn.RelyingParty {
+	return webauthn.NewRelyingParty(webauthn.Config{
+		RPID:    testRPID,
+		RPName:  "Iris",
+		Origins: []string{testOrigin},
+	}, challenges)
+}
+
+func newUser() model.User {
+	return model.User{IdUser: 1, IdExternal: uuid.New(), Email: "ada@example.com", FirstName: "Ada"}
+}
+
+// register runs a registration ceremony that is expected to succeed
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator, user model.User) *model.WebAuthnCredential {
+	t.Helper()
+
+	ctx := context.Background()
+	options, err := rp.BeginRegistration(ctx, user, nil)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	response, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	credential, err := rp.FinishRegistration(ctx, user, *response)
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return credential
+}
+
+// login runs the client side of a login ceremony for credential
+func login(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator, credential *model.WebAuthnCredential) *webauthn.AssertionResponse {
+	t.Helper()
+
+	options, err := rp.BeginLogin(context.Background(), []model.WebAuthnCredential{*credential})
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	response, err := authenticator.Login(options)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return response
+}
+
+func expectVerificationError(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	if !errors.Is(err, webauthn.ErrVerification) {
+		t.Fatalf("expected ErrVerification, got %v", err)
+	}
+	if !strings.Contains(err.Error(), reason) {
+		t.Fatalf("expected the error to mention %q, got %q", reason, err)
+	}
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+
+	credential := register(t, rp, authenticator, user)
+	if credential.UserId != user.IdUser {
+		t.Fatalf("credential belongs to user %d, want %d", credential.UserId, user.IdUser)
+	}
+	if credential.SignCount != 0 {
+		t.Fatalf("new credential has sign count %d, want 0", credential.SignCount)
+	}
+
+	for want := uint32(1); want <= 2; want++ {
+		response := login(t, rp, authenticator, credential)
+		if webauthn.CredentialIdFromResponse(*response) != credential.CredentialId {
+			t.Fatalf("assertion names credential %q, want %q", response.Id, credential.CredentialId)
+		}
+		signCount, err := rp.FinishLogin(context.Background(), *credential, user, *response)
+		if err != nil {
+			t.Fatalf("FinishLogin: %v", err)
+		}
+		if signCount != want {
+			t.Fatalf("sign count is %d, want %d", signCount, want)
+		}
+		credential.SignCount = signCount
+	}
+}
+
+func TestRegistrationRejectsExcludedAuthenticator(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+	credential := register(t, rp, authenticator, user)
+
+	options, err := rp.BeginRegistration(context.Background(), user, []model.WebAuthnCredential{*credential})
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	if _, err := authenticator.Register(options); err == nil {
+		t.Fatal("expected the authenticator to refuse registering a second credential")
+	}
+}
+
+func TestLoginRejectsSignCountRegression(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+	credential := register(t, rp, authenticator, user)
+
+	signCount, err := rp.FinishLogin(context.Background(), *credential, user, *login(t, rp, authenticator, credential))
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	credential.SignCount = signCount
+
+	// A clone of the authenticator still counts from where it was copied
+	authenticator.SetSignCount(credential.CredentialId, 0)
+	_, err = rp.FinishLogin(context.Background(), *credential, user, *login(t, rp, authenticator, credential))
+	if !errors.Is(err, webauthn.ErrSignCountRegressed) {
+		t.Fatalf("expected ErrSignCountRegressed, got %v", err)
+	}
+}
+
+func TestRejectsWrongOrigin(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	user := newUser()
+
+	t.Run("registration", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator("https://app.example.com.evil.test")
+		options, err := rp.BeginRegistration(context.Background(), user, nil)
+		if err != nil {
+			t.Fatalf("BeginRegistration: %v", err)
+		}
+		response, err := authenticator.Register(options)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		_, err = rp.FinishRegistration(context.Background(), user, *response)
+		expectVerificationError(t, err, "origin")
+	})
+
+	t.Run("login", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator, user)
+		authenticator.Origin = "https://evil.test"
+		_, err := rp.FinishLogin(context.Background(), *credential, user, *login(t, rp, authenticator, credential))
+		expectVerificationError(t, err, "origin")
+	})
+}
+
+func TestRejectsWrongRpIdHash(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	user := newUser()
+
+	t.Run("registration", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		options, err := rp.BeginRegistration(context.Background(), user, nil)
+		if err != nil {
+			t.Fatalf("BeginRegistration: %v", err)
+		}
+		// A phishing page relays the challenge under its own relying party id
+		options.Rp.Id = "evil.test"
+		response, err := authenticator.Register(options)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		_, err = rp.FinishRegistration(context.Background(), user, *response)
+		expectVerificationError(t, err, "relying party id")
+	})
+
+	t.Run("login", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator, user)
+		response := login(t, rp, authenticator, credential)
+
+		authData, err := base64.RawURLEncoding.DecodeString(response.Response.AuthenticatorData)
+		if err != nil {
+			t.Fatalf("decoding authenticator data: %v", err)
+		}
+		authData[0] ^= 0xff
+		response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+
+		_, err = rp.FinishLogin(context.Background(), *credential, user, *response)
+		expectVerificationError(t, err, "relying party id")
+	})
+}
+
+func TestRejectsMissingUserPresence(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	user := newUser()
+
+	t.Run("registration", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		authenticator.OmitUserPresence = true
+		options, err := rp.BeginRegistration(context.Background(), user, nil)
+		if err != nil {
+			t.Fatalf("BeginRegistration: %v", err)
+		}
+		response, err := authenticator.Register(options)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		_, err = rp.FinishRegistration(context.Background(), user, *response)
+		expectVerificationError(t, err, "not present")
+	})
+
+	t.Run("login", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator, user)
+		authenticator.OmitUserPresence = true
+		_, err := rp.FinishLogin(context.Background(), *credential, user, *login(t, rp, authenticator, credential))
+		expectVerificationError(t, err, "not present")
+	})
+}
+
+func TestLoginRejectsReplayedAssertion(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+	credential := register(t, rp, authenticator, user)
+
+	response := login(t, rp, authenticator, credential)
+	if _, err := rp.FinishLogin(context.Background(), *credential, user, *response); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	_, err := rp.FinishLogin(context.Background(), *credential, user, *response)
+	if !errors.Is(err, webauthn.ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge on replay, got %v", err)
+	}
+}
+
+func TestRegistrationRejectsChallengeOfAnotherUser(t *testing.T) {
+	rp := newRelyingParty(webauthntest.NewChallengeStore())
+	authenticator := webauthntest.NewAuthenticator(testOrigin)
+	user := newUser()
+
+	options, err := rp.BeginRegistration(context.Background(), user, nil)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	response, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	other := newUser()
+	other.IdUser = 2
+	if _, err := rp.FinishRegistration(context.Background(), other, *response); !errors.Is(err, webauthn.ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge, got %v", err)
+	}
+}
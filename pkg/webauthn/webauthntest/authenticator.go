@@ -0,0 +1,257 @@
+// Package webauthntest provides a software authenticator and an in-memory
+// challenge store for exercising passkey ceremonies in Go tests.
+package webauthntest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/SomtoJF/iris-api/pkg/webauthn"
+	"github.com/ugorji/go/codec"
+)
+
+var ErrNoCredential = errors.New("authenticator holds no matching credential")
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpId       string
+	userHandle string
+	signCount  uint32
+}
+
+// Authenticator is a software passkey authenticator that creates ES256
+// credentials and reports user verification and, unless told otherwise,
+// user presence
+type Authenticator struct {
+	// Origin reported in the client data, e.g. https://app.example.com
+	Origin string
+	// Aaguid identifies the authenticator model, zero by default
+	Aaguid [16]byte
+	// OmitUserPresence leaves the user present flag unset, as for a request
+	// answered without any interaction
+	OmitUserPresence bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Register answers navigator.credentials.create() for options
+func (a *Authenticator) Register(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.Rp.Id, excluded.Id) != nil {
+			return nil, errors.New("authenticator already holds an excluded credential")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	cred := &credential{id: id, key: key, rpId: options.Rp.Id, userHandle: options.User.Id}
+	a.credentials = append(a.credentials, cred)
+
+	point, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := encodeCBOR(map[int64]interface{}{
+		1:  2,
+		3:  webauthn.AlgES256,
+		-1: 1,
+		-2: point[1:33],
+		-3: point[33:],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attested := append([]byte{}, a.Aaguid[:]...)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, publicKey...)
+
+	authData := authenticatorData(options.Rp.Id, a.flags()|0x40, cred.signCount)
+	authData = append(authData, attested...)
+
+	attestationObject, err := encodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.RegistrationResponse{
+		Id:   encode(id),
+		Type: "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    encode(clientDataJSON),
+			AttestationObject: encode(attestationObject),
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Login answers navigator.credentials.get() for options using the first
+// matching credential, or any credential for the relying party when options
+// allow discoverable credentials
+func (a *Authenticator) Login(options *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		for _, candidate := range a.credentials {
+			if candidate.rpId == options.RpId {
+				cred = candidate
+				break
+			}
+		}
+	}
+	for _, allowed := range options.AllowCredentials {
+		if cred = a.find(options.RpId, allowed.Id); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	cred.signCount++
+	authData := authenticatorData(options.RpId, a.flags(), cred.signCount)
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.AssertionResponse{
+		Id:   encode(cred.id),
+		Type: "public-key",
+		Response: webauthn.AssertionResponseData{
+			ClientDataJSON:    encode(clientDataJSON),
+			AuthenticatorData: encode(authData),
+			Signature:         encode(signature),
+			UserHandle:        cred.userHandle,
+		},
+	}, nil
+}
+
+// SetSignCount overrides a credential's signature counter, for example to
+// simulate a cloned authenticator
+func (a *Authenticator) SetSignCount(credentialId string, signCount uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, cred := range a.credentials {
+		if encode(cred.id) == credentialId {
+			cred.signCount = signCount
+		}
+	}
+}
+
+func (a *Authenticator) find(rpId string, credentialId string) *credential {
+	for _, cred := range a.credentials {
+		if cred.rpId == rpId && encode(cred.id) == credentialId {
+			return cred
+		}
+	}
+	return nil
+}
+
+// flags returns the user present and user verified flags to report
+func (a *Authenticator) flags() byte {
+	if a.OmitUserPresence {
+		return 0x04
+	}
+	return 0x01 | 0x04
+}
+
+func (a *Authenticator) clientData(ceremonyType string, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+// ChallengeStore is an in-memory webauthn.ChallengeStore
+type ChallengeStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]webauthn.Ceremony
+}
+
+func NewChallengeStore() *ChallengeStore {
+	return &ChallengeStore{ceremonies: make(map[string]webauthn.Ceremony)}
+}
+
+func (s *ChallengeStore) Save(ctx context.Context, challenge string, ceremony webauthn.Ceremony) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ceremonies[challenge] = ceremony
+	return nil
+}
+
+func (s *ChallengeStore) Take(ctx context.Context, challenge string) (*webauthn.Ceremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ceremony, ok := s.ceremonies[challenge]
+	if !ok {
+		return nil, webauthn.ErrInvalidChallenge
+	}
+	delete(s.ceremonies, challenge)
+	return &ceremony, nil
+}
+
+func authenticatorData(rpId string, flags byte, signCount uint32) []byte {
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	data := append([]byte{}, rpIdHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+func encodeCBOR(value interface{}) ([]byte, error) {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, &codec.CborHandle{}).Encode(value)
+	return out, err
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,150 @@
+package temporal
+
+import (
+	"context"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	failurepb "go.temporal.io/api/failure/v1"
+	"go.temporal.io/sdk/client"
+)
+
+type WorkflowEventType string
+
+const (
+	WorkflowEventStarted       WorkflowEventType = "workflow_started"
+	WorkflowEventCompleted     WorkflowEventType = "workflow_completed"
+	WorkflowEventFailed        WorkflowEventType = "workflow_failed"
+	WorkflowEventTimedOut      WorkflowEventType = "workflow_timed_out"
+	WorkflowEventCancelRequest WorkflowEventType = "workflow_cancel_requested"
+	WorkflowEventCancelled     WorkflowEventType = "workflow_cancelled"
+	WorkflowEventTerminated    WorkflowEventType = "workflow_terminated"
+	WorkflowEventStepScheduled WorkflowEventType = "step_scheduled"
+	WorkflowEventStepStarted   WorkflowEventType = "step_started"
+	WorkflowEventStepCompleted WorkflowEventType = "step_completed"
+	WorkflowEventStepFailed    WorkflowEventType = "step_failed"
+	WorkflowEventStepTimedOut  WorkflowEventType = "step_timed_out"
+	WorkflowEventStepCancelled WorkflowEventType = "step_cancelled"
+)
+
+// Reasons shown to users for a failed, timed out or stopped run or step.
+// The messages recorded by Temporal can hold stack traces, internal hosts and
+// data of other systems, so users only ever see one of these.
+const (
+	ReasonFailed       = "The application could not be completed"
+	ReasonTimedOut     = "The application took too long and was stopped"
+	ReasonTerminated   = "The application was stopped"
+	ReasonStepFailed   = "A step of the application failed"
+	ReasonStepTimedOut = "A step of the application took too long"
+)
+
+// WorkflowEvent is a workflow or activity milestone from a workflow's
+// history. Step is the activity name and Attempt its retry attempt for step
+// events. Message is one of the Reason constants for failures, timeouts and
+// terminations, while Detail keeps the raw message Temporal recorded, which
+// is only fit for logs.
+type WorkflowEvent struct {
+	Type    WorkflowEventType
+	At      time.Time
+	Step    string
+	Attempt int32
+	Message string
+	Detail  string
+}
+
+// WorkflowHistory reads the milestones of a workflow run in the order they
+// happened. Workflow task bookkeeping, signals and markers are left out.
+func WorkflowHistory(ctx context.Context, temporalClient client.Client, workflowId string, runId string) ([]WorkflowEvent, error) {
+	iterator := temporalClient.GetWorkflowHistory(ctx, workflowId, runId, false, enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+
+	// Later activity events only reference the event that scheduled them
+	stepNames := make(map[int64]string)
+	var events []WorkflowEvent
+	for iterator.HasNext() {
+		historyEvent, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		event := WorkflowEvent{At: historyEvent.GetEventTime().AsTime()}
+		switch historyEvent.GetEventType() {
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED:
+			event.Type = WorkflowEventStarted
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED:
+			event.Type = WorkflowEventCompleted
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_FAILED:
+			event.Type = WorkflowEventFailed
+			event.Message = ReasonFailed
+			event.Detail = failureMessage(historyEvent.GetWorkflowExecutionFailedEventAttributes().GetFailure())
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_TIMED_OUT:
+			event.Type = WorkflowEventTimedOut
+			event.Message = ReasonTimedOut
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CANCEL_REQUESTED:
+			event.Type = WorkflowEventCancelRequest
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CANCELED:
+			event.Type = WorkflowEventCancelled
+		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_TERMINATED:
+			event.Type = WorkflowEventTerminated
+			event.Message = ReasonTerminated
+			event.Detail = historyEvent.GetWorkflowExecutionTerminatedEventAttributes().GetReason()
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED:
+			event.Type = WorkflowEventStepScheduled
+			event.Step = historyEvent.GetActivityTaskScheduledEventAttributes().GetActivityType().GetName()
+			stepNames[historyEvent.GetEventId()] = event.Step
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_STARTED:
+			attributes := historyEvent.GetActivityTaskStartedEventAttributes()
+			event.Type = WorkflowEventStepStarted
+			event.Step = stepNames[attributes.GetScheduledEventId()]
+			event.Attempt = attributes.GetAttempt()
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_COMPLETED:
+			event.Type = WorkflowEventStepCompleted
+			event.Step = stepNames[historyEvent.GetActivityTaskCompletedEventAttributes().GetScheduledEventId()]
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_FAILED:
+			attributes := historyEvent.GetActivityTaskFailedEventAttributes()
+			event.Type = WorkflowEventStepFailed
+			event.Step = stepNames[attributes.GetScheduledEventId()]
+			event.Message = ReasonStepFailed
+			event.Detail = failureMessage(attributes.GetFailure())
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_TIMED_OUT:
+			attributes := historyEvent.GetActivityTaskTimedOutEventAttributes()
+			event.Type = WorkflowEventStepTimedOut
+			event.Step = stepNames[attributes.GetScheduledEventId()]
+			event.Message = ReasonStepTimedOut
+			event.Detail = failureMessage(attributes.GetFailure())
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_CANCELED:
+			event.Type = WorkflowEventStepCancelled
+			event.Step = stepNames[historyEvent.GetActivityTaskCanceledEventAttributes().GetScheduledEventId()]
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// FailureReason returns the user-safe reason the workflow ended, falling
+// back to the last failed step, or "" when nothing failed
+func FailureReason(events []WorkflowEvent) string {
+	reason := ""
+	for _, event := range events {
+		switch event.Type {
+		case WorkflowEventFailed, WorkflowEventTimedOut, WorkflowEventTerminated:
+			return event.Message
+		case WorkflowEventStepFailed, WorkflowEventStepTimedOut:
+			reason = event.Message
+		}
+	}
+	return reason
+}
+
+// failureMessage returns the innermost cause's message, which is the one
+// raised by the application rather than a wrapper added by Temporal
+func failureMessage(failure *failurepb.Failure) string {
+	message := ""
+	for ; failure != nil; failure = failure.GetCause() {
+		if failure.GetMessage() != "" {
+			message = failure.GetMessage()
+		}
+	}
+	return message
+}
@@ -0,0 +1,25 @@
+package temporal
+
+import (
+	"context"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+)
+
+// WaitForClose blocks until the workflow run closes or ctx is done and
+// returns how the run closed
+func WaitForClose(ctx context.Context, temporalClient client.Client, workflowId string, runId string) (enumspb.WorkflowExecutionStatus, error) {
+	// The run's result error only tells that it did not complete, so the
+	// status is read back once the run has closed
+	_ = temporalClient.GetWorkflow(ctx, workflowId, runId).Get(ctx, nil)
+	if err := ctx.Err(); err != nil {
+		return enumspb.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED, err
+	}
+
+	description, err := temporalClient.DescribeWorkflowExecution(ctx, workflowId, runId)
+	if err != nil {
+		return enumspb.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED, err
+	}
+	return description.GetWorkflowExecutionInfo().GetStatus(), nil
+}